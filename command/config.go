@@ -11,7 +11,7 @@ import (
 func runConfigCommand(cmd *cobra.Command, args []string) error {
 	fmt.Println("strategy:", pkg.Config.Strategy)
 	fmt.Println("location:", pkg.Config.GetRepositoryLocation())
-	fmt.Printf("current commit: %s\n", pkg.Config.Commit[:7])
+	fmt.Printf("current commit: %s\n", shortCommit(pkg.Config.Commit))
 	fmt.Println("branch:", pkg.Config.Branch)
 	if pkg.Config.Dir != "" {
 		fmt.Println("subdir:", pkg.Config.Dir)
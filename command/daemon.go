@@ -0,0 +1,254 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/redwebcreation/nest/util"
+	"github.com/spf13/cobra"
+)
+
+var daemonListen string
+var collectInterval time.Duration
+var unitPath string
+var printUnit bool
+
+func runDaemonCommand(cmd *cobra.Command, args []string) error {
+	config, err := pkg.RetrieveConfig()
+	if err != nil {
+		return err
+	}
+
+	proxy := pkg.NewProxy(config)
+
+	certificates, err := pkg.NewCertificateManager(config)
+	if err != nil {
+		return err
+	}
+
+	server := pkg.NewServer("", "")
+	server.Queue = true
+	server.OnFinish = func(deployment *pkg.Deployment) {
+		fmt.Printf("Deployment %s finished: %s\n", deployment.ID, deploymentOutcome(deployment))
+
+		// the proxy follows the configuration of the latest deployment
+		config, err := pkg.RetrieveConfig()
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "could not reload the configuration: %s\n", err)
+			return
+		}
+
+		proxy.Reload(config)
+		certificates.Reload(config)
+	}
+
+	if daemonListen != "" {
+		err = loadAPISecrets()
+		if err != nil {
+			return err
+		}
+
+		server.Token = token
+		server.WebhookSecret = webhookSecret
+	}
+
+	listener, err := pkg.ListenSocket()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(pkg.SocketPath())
+
+	failures := make(chan error)
+
+	go func() {
+		failures <- http.Serve(listener, server.SocketHandler())
+	}()
+
+	serveProxy(config, proxy, certificates, failures)
+
+	fmt.Printf("Daemon listening on %s\n", pkg.SocketPath())
+	fmt.Printf("Proxy listening on %s and %s\n", config.Proxy.HTTP, config.Proxy.HTTPS)
+
+	if daemonListen != "" {
+		go func() {
+			failures <- http.ListenAndServe(daemonListen, server.Handler())
+		}()
+
+		fmt.Printf("API listening on %s\n", daemonListen)
+	}
+
+	if collectInterval > 0 {
+		go collectPeriodically(server, collectInterval)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err = <-failures:
+		return err
+	case sig := <-signals:
+		fmt.Printf("Received %s, stopping.\n", sig)
+
+		return nil
+	}
+}
+
+// collectPeriodically removes the stale containers at every interval, unless a deployment is in progress.
+func collectPeriodically(server *pkg.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := server.Collect()
+		if errors.Is(err, pkg.ErrDeploymentInProgress) {
+			continue
+		}
+
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "could not collect the stale containers: %s\n", err)
+			continue
+		}
+
+		for _, event := range result.Events {
+			fmt.Printf("%s: %s\n", event.Service, event.Message)
+		}
+
+		for _, err := range result.Errors {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// deploymentOutcome counts the services of the deployment by status, such as "2 succeeded, 1 failed".
+func deploymentOutcome(deployment *pkg.Deployment) string {
+	snapshot := deployment.Snapshot()
+	if snapshot.Error != "" {
+		return snapshot.Error
+	}
+
+	succeeded, failed := 0, 0
+
+	for _, service := range snapshot.Services {
+		if service.Status == pkg.StatusSucceeded {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+
+	return fmt.Sprintf("%d succeeded, %d failed", succeeded, failed)
+}
+
+func runDaemonInstallCommand(cmd *cobra.Command, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return err
+	}
+
+	// the flags given to install are passed on to the daemon
+	var flags []string
+
+	if cmd.Flags().Changed("listen") {
+		flags = append(flags, "--listen="+daemonListen)
+	}
+
+	if cmd.Flags().Changed("collect-interval") {
+		flags = append(flags, "--collect-interval="+collectInterval.String())
+	}
+
+	unit := systemdUnit(executable, current.Username, flags)
+
+	if printUnit {
+		fmt.Print(unit)
+		return nil
+	}
+
+	err = os.WriteFile(unitPath, []byte(unit), 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s, start the daemon with:\n\n", unitPath)
+	fmt.Println(util.White.Fg() + "  systemctl daemon-reload && systemctl enable --now nest" + util.Reset)
+
+	return nil
+}
+
+// systemdUnit returns a systemd unit running the daemon as the given user.
+func systemdUnit(executable string, username string, flags []string) string {
+	var unit strings.Builder
+
+	unit.WriteString(`[Unit]
+Description=Nest service orchestrator
+Documentation=https://github.com/redwebcreation/nest
+After=network-online.target docker.service
+Wants=network-online.target
+Requires=docker.service
+
+[Service]
+Type=simple
+`)
+
+	fmt.Fprintf(&unit, "User=%s\n", username)
+
+	// the proxy listens on the privileged ports 80 and 443
+	if username != "root" {
+		unit.WriteString("AmbientCapabilities=CAP_NET_BIND_SERVICE\n")
+	}
+
+	unit.WriteString("# NEST_API_TOKEN and NEST_WEBHOOK_SECRET may be set in this file\n")
+	unit.WriteString("EnvironmentFile=-/etc/default/nest\n")
+	fmt.Fprintf(&unit, "ExecStart=%s\n", strings.Join(append([]string{executable, "daemon"}, flags...), " "))
+
+	unit.WriteString(`Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`)
+
+	return unit.String()
+}
+
+// NewDaemonCommand runs the proxy, the deployments, the container collector and the API in a single process
+func NewDaemonCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "run the proxy, the deployments and the API",
+		Args:  cobra.NoArgs,
+		RunE:  runDaemonCommand,
+	}
+
+	cmd.PersistentFlags().StringVarP(&daemonListen, "listen", "l", "", "also serve the API over http on this address, authenticated by tokens")
+	cmd.PersistentFlags().DurationVar(&collectInterval, "collect-interval", time.Hour, "how often stale containers are removed, 0 disables it")
+	cmd.Flags().StringVar(&token, "token", "", "token authenticating the requests over http with every scope, defaults to $NEST_API_TOKEN")
+	cmd.Flags().StringVar(&webhookSecret, "webhook-secret", "", "secret signing the push webhooks of the git provider, defaults to $NEST_WEBHOOK_SECRET")
+
+	install := &cobra.Command{
+		Use:   "install",
+		Short: "install the daemon as a systemd service",
+		Args:  cobra.NoArgs,
+		RunE:  runDaemonInstallCommand,
+	}
+
+	install.Flags().StringVar(&unitPath, "path", "/etc/systemd/system/nest.service", "where to write the systemd unit")
+	install.Flags().BoolVar(&printUnit, "print", false, "print the unit instead of writing it")
+
+	cmd.AddCommand(install)
+
+	return cmd
+}
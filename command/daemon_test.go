@@ -0,0 +1,26 @@
+package command
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemdUnit(t *testing.T) {
+	unit := systemdUnit("/usr/local/bin/nest", "deploy", []string{"--listen=0.0.0.0:8000"})
+
+	for _, line := range []string{
+		"User=deploy\n",
+		"AmbientCapabilities=CAP_NET_BIND_SERVICE\n",
+		"ExecStart=/usr/local/bin/nest daemon --listen=0.0.0.0:8000\n",
+		"Requires=docker.service\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(unit, line) {
+			t.Errorf("Expected the unit to contain %q, got:\n%s", line, unit)
+		}
+	}
+
+	if unit = systemdUnit("/usr/local/bin/nest", "root", nil); strings.Contains(unit, "AmbientCapabilities") {
+		t.Errorf("Expected root to bind the privileged ports without capabilities")
+	}
+}
@@ -0,0 +1,279 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/redwebcreation/nest/util"
+)
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressWidth is the number of characters of the pull progress bar.
+const progressWidth = 20
+
+// messageWidth is the maximum length of the last message of a service displayed by the live view.
+const messageWidth = 60
+
+// layerStatuses are the pull statuses about a single layer, they are too noisy for the plain output.
+var layerStatuses = map[string]bool{
+	"Pulling fs layer":   true,
+	"Waiting":            true,
+	"Downloading":        true,
+	"Verifying Checksum": true,
+	"Download complete":  true,
+	"Extracting":         true,
+	"Pull complete":      true,
+	"Already exists":     true,
+}
+
+// serviceProgress is what the dashboard knows about the deployment of a service.
+type serviceProgress struct {
+	image      string
+	phase      string
+	message    string
+	current    int64
+	total      int64
+	status     string
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// dashboard prints the progress of a deployment.
+// The live view redraws a row per service in place, the plain view prints a line per event.
+type dashboard struct {
+	out       io.Writer
+	live      bool
+	names     []string
+	services  map[string]*serviceProgress
+	startedAt time.Time
+	frame     int
+	drawn     int
+}
+
+func newDashboard(out io.Writer, services pkg.ServiceMap, live bool) *dashboard {
+	d := &dashboard{
+		out:       out,
+		live:      live,
+		services:  make(map[string]*serviceProgress, len(services)),
+		startedAt: time.Now(),
+	}
+
+	for name := range services {
+		d.names = append(d.names, name)
+		d.services[name] = &serviceProgress{
+			image:     services[name].Image,
+			phase:     "pending",
+			status:    pkg.StatusPending,
+			startedAt: d.startedAt,
+		}
+	}
+
+	sort.Strings(d.names)
+
+	return d
+}
+
+// Update records the event, the plain view prints it right away.
+func (d *dashboard) Update(message pkg.Message) {
+	service, ok := d.services[message.Service.Name]
+	if !ok {
+		return
+	}
+
+	service.message = message.Event.String()
+
+	switch event := message.Event.(type) {
+	case pkg.PullProgress:
+		service.phase = "pulling"
+		service.current = event.Current
+		service.total = event.Total
+
+		if !d.live && layerStatuses[event.Status] {
+			return
+		}
+	case pkg.ResourceCreated:
+		service.phase = "preparing"
+	case pkg.WaitingForDependency:
+		service.phase = "waiting"
+	case pkg.HookStarted:
+		service.phase = event.Stage
+	case pkg.HookOutput:
+		service.phase = event.Stage
+	case pkg.HookFinished:
+		service.phase = event.Stage
+	case pkg.ContainerCreated:
+		service.phase = "creating"
+	case pkg.ContainerStarted:
+		service.phase = "starting"
+	case pkg.HealthStatus:
+		service.phase = "health check"
+	case pkg.TrafficSwitched:
+		service.phase = "switching"
+	case pkg.ContainerRemoved:
+		service.phase = "cleaning up"
+	case pkg.Completed:
+		service.phase = "deployed"
+		service.status = pkg.StatusSucceeded
+		service.finishedAt = time.Now()
+	case pkg.Failed:
+		service.phase = "failed"
+		service.status = pkg.StatusFailed
+		service.finishedAt = time.Now()
+	}
+
+	if !d.live {
+		_, _ = fmt.Fprintf(d.out, "%s: %s\n", message.Service.Name, service.message)
+	}
+}
+
+// Draw redraws the rows of the live view over the previous ones.
+func (d *dashboard) Draw() {
+	if !d.live {
+		return
+	}
+
+	if d.drawn > 0 {
+		// move the cursor to the beginning of the first row
+		_, _ = fmt.Fprintf(d.out, "\x1b[%dF", d.drawn)
+	}
+
+	width := 0
+	for _, name := range d.names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for _, name := range d.names {
+		_, _ = fmt.Fprintf(d.out, "\x1b[2K%s\n", d.row(name, width))
+	}
+
+	d.drawn = len(d.names)
+	d.frame++
+}
+
+func (d *dashboard) row(name string, width int) string {
+	service := d.services[name]
+
+	var icon string
+	var color util.Color
+
+	switch service.status {
+	case pkg.StatusSucceeded:
+		icon, color = "✔", util.Green
+	case pkg.StatusFailed:
+		icon, color = "✘", util.Red
+	default:
+		icon, color = spinner[d.frame%len(spinner)], util.Blue
+	}
+
+	row := fmt.Sprintf("%s%s%s %-*s  %-12s  ", color, icon, util.Reset, width, name, service.phase)
+
+	if service.phase == "pulling" && service.total > 0 {
+		row += progressBar(service.current, service.total) + " " + units.HumanSize(float64(service.current)) + "/" + units.HumanSize(float64(service.total))
+	} else {
+		row += truncate(service.message, messageWidth)
+	}
+
+	return row + "  " + util.Gray.Fg() + d.elapsed(service).String() + util.Reset
+}
+
+func (d *dashboard) elapsed(service *serviceProgress) time.Duration {
+	if service.finishedAt.IsZero() {
+		return time.Since(service.startedAt).Round(100 * time.Millisecond)
+	}
+
+	return service.finishedAt.Sub(service.startedAt).Round(100 * time.Millisecond)
+}
+
+// Finish draws the view a last time and prints a table summarizing the outcome of every service.
+func (d *dashboard) Finish() {
+	d.Draw()
+
+	rows := [][]string{{"SERVICE", "STATUS", "IMAGE", "DURATION", "ERROR"}}
+	colors := []util.Color{util.White}
+
+	var succeeded int
+
+	for _, name := range d.names {
+		service := d.services[name]
+
+		var message string
+		color := util.Gray
+
+		switch service.status {
+		case pkg.StatusSucceeded:
+			succeeded++
+			color = util.Green
+		case pkg.StatusFailed:
+			color = util.Red
+			message = service.message
+		}
+
+		rows = append(rows, []string{name, service.status, service.image, d.elapsed(service).String(), message})
+		colors = append(colors, color)
+	}
+
+	// the widths are computed before coloring, escape codes would be counted otherwise
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := len([]rune(cell)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	_, _ = fmt.Fprintln(d.out)
+
+	for i, row := range rows {
+		line := "  "
+
+		for j, cell := range row {
+			padding := strings.Repeat(" ", widths[j]-len([]rune(cell)))
+
+			if j == 1 || i == 0 {
+				cell = colors[i].Fg() + cell + util.Reset
+			}
+
+			line += cell + padding + "  "
+		}
+
+		_, _ = fmt.Fprintln(d.out, strings.TrimRight(line, " "))
+	}
+
+	color := util.Green
+	if succeeded < len(d.names) {
+		color = util.Yellow
+	}
+
+	if succeeded == 0 {
+		color = util.Red
+	}
+
+	_, _ = fmt.Fprintf(d.out, "\n%sDeployed %d of %d %s in %s.%s\n", color, succeeded, len(d.names), util.Plural(len(d.names), "service", "services"), time.Since(d.startedAt).Round(100*time.Millisecond), util.Reset)
+}
+
+func progressBar(current int64, total int64) string {
+	filled := int(current * progressWidth / total)
+	if filled > progressWidth {
+		filled = progressWidth
+	}
+
+	return util.Blue.Fg() + strings.Repeat("█", filled) + util.Gray.Fg() + strings.Repeat("░", progressWidth-filled) + util.Reset
+}
+
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+
+	return string(runes[:length-1]) + "…"
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/redwebcreation/nest/pkg"
+)
+
+func TestDashboard_Plain(t *testing.T) {
+	api := &pkg.Service{Name: "api", Image: "api:1.0"}
+	worker := &pkg.Service{Name: "worker", Image: "worker:1.0"}
+
+	var out bytes.Buffer
+
+	view := newDashboard(&out, pkg.ServiceMap{"api": api, "worker": worker}, false)
+	view.Draw()
+
+	view.Update(pkg.Message{Service: api, Event: pkg.PullProgress{Status: "Pulling from library/api"}})
+	view.Update(pkg.Message{Service: api, Event: pkg.PullProgress{Status: "Downloading", Current: 10, Total: 100}})
+	view.Update(pkg.Message{Service: api, Event: pkg.Completed{}})
+	view.Update(pkg.Message{Service: worker, Event: pkg.Failed{Error: "container never became healthy"}})
+
+	lines := strings.Split(out.String(), "\n")
+
+	expected := []string{
+		"api: Pulling from library/api",
+		"api: deployed",
+		"worker: container never became healthy",
+	}
+
+	for i, line := range expected {
+		if lines[i] != line {
+			t.Errorf("Expected line %d to be %q, got %q", i, line, lines[i])
+		}
+	}
+
+	view.Finish()
+
+	summary := regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(out.String(), "")
+
+	if !strings.Contains(summary, "worker   failed     worker:1.0  0s        container never became healthy") {
+		t.Errorf("Expected the summary table to list the failed service, got %s", summary)
+	}
+
+	if !strings.Contains(summary, "Deployed 1 of 2 services") {
+		t.Errorf("Expected the summary to count the deployed services, got %s", summary)
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	bar := progressBar(50, 100)
+
+	if strings.Count(bar, "█") != progressWidth/2 || strings.Count(bar, "░") != progressWidth/2 {
+		t.Errorf("Expected a half filled progress bar, got %s", bar)
+	}
+}
@@ -1,105 +1,312 @@
 package command
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/redwebcreation/nest/pkg"
 	"github.com/redwebcreation/nest/util"
 	"github.com/spf13/cobra"
-	"sort"
-	"strconv"
+	"io"
+	"os"
 	"strings"
 	"time"
 )
 
+var plan bool
+var force bool
+var servicePatterns []string
+var output string
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// deploySummary is the last line printed by `nest deploy --output json`.
+type deploySummary struct {
+	// Type is always summary, it tells the summary apart from the events printed before it.
+	Type       string                            `json:"type"`
+	Deployment string                            `json:"deployment,omitempty"`
+	Commit     string                            `json:"commit"`
+	Services   map[string]*pkg.ServiceDeployment `json:"services"`
+	// Skipped lists the services that were up to date.
+	Skipped []string `json:"skipped,omitempty"`
+	// Error is why the deployment failed, if it did.
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
 func runDeployCommand(cmd *cobra.Command, args []string) error {
-	config, err := pkg.Config.Retrieve()
-	if err != nil {
-		return err
+	if output != outputText && output != outputJSON {
+		return fmt.Errorf("unknown output format %s, use %s or %s", output, outputText, outputJSON)
 	}
 
-	// re-use the previous commit
-	if len(args) == 0 && pkg.Config.Commit != "" {
-		err := pkg.LoadConfigFromCommit(pkg.Config.Commit)
-		if err != nil {
-			return err
-		}
+	request := pkg.DeployRequest{
+		Services: servicePatterns,
+		Force:    force,
 	}
 
 	if len(args) == 1 {
-		err := pkg.Config.Git.Pull(pkg.Config.Branch)
-		if err != nil {
-			return err
-		}
+		request.Commit = args[0]
+	}
 
-		commits, err := pkg.Config.Git.Commits()
-		if err != nil {
+	// the daemon deploys when it runs, so that its deployments do not race with ours
+	if !plan && pkg.DaemonRunning() {
+		return deployThroughDaemon(daemonRequest(request))
+	}
+
+	// the summary is printed in json mode even if the deployment fails before it starts
+	summary := deploySummary{
+		Type:     "summary",
+		Services: make(map[string]*pkg.ServiceDeployment),
+	}
+
+	config, services, err := request.Load()
+	if err != nil {
+		if plan {
 			return err
 		}
 
-		var commit string
+		return printSummary(os.Stdout, summary, nil, err)
+	}
 
-		for _, c := range commits {
-			if c == args[0] || strings.HasPrefix(c, args[0]) {
-				commit = c
-				break
-			}
+	if plan {
+		return printPlan(config, services)
+	}
+
+	summary.Commit = pkg.Config.Commit
+
+	services, skipped, err := request.Outdated(services)
+	if err != nil {
+		return printSummary(os.Stdout, summary, nil, err)
+	}
+
+	summary.Skipped = skipped
+
+	if output == outputText && len(services) == 0 {
+		fmt.Println("Every service is up to date, use --force to redeploy them.")
+		return nil
+	}
+
+	if output == outputText && len(skipped) > 0 {
+		fmt.Printf("Skipping %d up to date %s.\n", len(skipped), util.Plural(len(skipped), "service", "services"))
+	}
+
+	var deployment *pkg.Deployment
+
+	if len(services) > 0 {
+		deployment, err = deploy("deploy", config, services)
+	}
+
+	return printSummary(os.Stdout, summary, deployment, err)
+}
+
+// printSummary writes the summary to w in json mode and returns the error of the deployment, if any.
+// The deployment is nil if every service was up to date or if it could not start, err is why it failed.
+func printSummary(w io.Writer, summary deploySummary, deployment *pkg.Deployment, err error) error {
+	failure := err
+
+	if deployment != nil && len(deployment.Services) > 0 {
+		summary.Deployment = deployment.ID
+		summary.Services = deployment.Services
+
+		if failure == nil {
+			failure = deploymentError(deployment)
 		}
+	}
+
+	if failure != nil {
+		summary.Error = failure.Error()
+		summary.ExitCode = 1
 
-		if commit == "" {
-			return fmt.Errorf("commit not found")
+		var exitError *ExitError
+		if errors.As(failure, &exitError) {
+			summary.ExitCode = exitError.Code
 		}
+	}
 
-		err = pkg.LoadConfigFromCommit(commit)
+	if output == outputJSON {
+		err = json.NewEncoder(w).Encode(summary)
 		if err != nil {
 			return err
 		}
 	}
 
-	inQueue := len(config.Services)
-	var messages = make(map[string]string, inQueue)
-	var messageBus = make(pkg.MessageBus)
+	return failure
+}
 
-	fmt.Printf("Using %s to deploy services.\n\n", util.White.Fg()+pkg.Config.Commit[:8]+util.Reset)
+// daemonRequest pins the request to the current commit unless it has one, as when nest deploys by itself.
+// The daemon would otherwise pull the latest commit of the branch.
+func daemonRequest(request pkg.DeployRequest) pkg.DeployRequest {
+	if request.Commit == "" {
+		request.Commit = pkg.Config.Commit
+	}
+
+	return request
+}
 
-	id := strconv.FormatInt(time.Now().UnixMilli(), 10)
+// deployThroughDaemon asks the daemon to deploy and prints the progress of the deployment until it finishes.
+func deployThroughDaemon(request pkg.DeployRequest) error {
+	client := pkg.NewDaemonClient()
 
-	for _, service := range config.Services {
-		messages[service.Name] = "idle"
+	deployment, err := client.Deploy(request)
+	if err != nil {
+		return printSummary(os.Stdout, deploySummary{
+			Type:     "summary",
+			Commit:   request.Commit,
+			Services: make(map[string]*pkg.ServiceDeployment),
+		}, nil, err)
+	}
 
-		go func(service *pkg.Service) {
-			err := service.Deploy(id, messageBus)
+	return followThroughDaemon(client, deployment)
+}
 
-			if err != nil {
-				messageBus <- pkg.Message{
-					Service: service,
-					Value:   err,
-				}
-			}
-		}(service)
+// followThroughDaemon prints the progress of a deployment run by the daemon until it finishes.
+func followThroughDaemon(client *pkg.DaemonClient, deployment *pkg.Deployment) error {
+	summary := deploySummary{
+		Type:     "summary",
+		Services: make(map[string]*pkg.ServiceDeployment),
+	}
+
+	// a queued deployment only knows its commit and its services once it starts
+	if deployment.Commit == "" && output == outputText {
+		fmt.Println("Waiting for the deployment in progress to finish.")
+	}
+
+	for deployment.Commit == "" && deployment.Error == "" {
+		time.Sleep(time.Second)
+
+		latest, err := client.Deployment(deployment.ID)
+		if err != nil {
+			return printSummary(os.Stdout, summary, nil, err)
+		}
+
+		deployment = latest
 	}
 
-	render(messages)
+	services := make(pkg.ServiceMap, len(deployment.Services))
+	for name, service := range deployment.Services {
+		services[name] = &pkg.Service{Name: name, Image: service.Image}
+	}
+
+	if output == outputText && len(services) > 0 {
+		if len(deployment.Skipped) > 0 {
+			fmt.Printf("Skipping %d up to date %s.\n", len(deployment.Skipped), util.Plural(len(deployment.Skipped), "service", "services"))
+		}
+
+		fmt.Printf("Using %s to deploy services.\n\n", util.White.Fg()+shortCommit(deployment.Commit)+util.Reset)
+	}
+
+	messages := make(chan pkg.Message)
 
-	for message := range messageBus {
-		if _, ok := message.Value.(error); ok {
-			messages[message.Service.Name] = message.Value.(error).Error()
-			inQueue--
+	var finished *pkg.Deployment
+	var followErr error
 
-			render(messages)
+	go func() {
+		defer close(messages)
 
-			if inQueue == 0 {
-				break
+		finished, followErr = client.Follow(deployment.ID, func(event pkg.RecordedEvent) {
+			if message, err := event.Decode(); err == nil {
+				messages <- message
 			}
+		})
+	}()
 
-			continue
+	if len(services) > 0 {
+		render(services, messages)
+	} else {
+		for range messages {
 		}
+	}
 
-		messages[message.Service.Name] = message.Value.(string)
+	summary.Commit = deployment.Commit
+	summary.Skipped = deployment.Skipped
 
-		render(messages)
+	if followErr != nil {
+		return printSummary(os.Stdout, summary, nil, followErr)
 	}
 
-	return nil
+	if finished.Error != "" {
+		return printSummary(os.Stdout, summary, finished, fmt.Errorf("%s", finished.Error))
+	}
+
+	if output == outputText && len(finished.Services) == 0 {
+		fmt.Println("Every service is up to date, use --force to redeploy them.")
+		return nil
+	}
+
+	return printSummary(os.Stdout, summary, finished, nil)
+}
+
+// deploy deploys the services using the configuration at the current commit and prints its progress.
+// The action, deploy or rollback, is recorded in the audit log.
+// The containers of the services missing from the configuration are collected, unless it is nil.
+func deploy(action string, config *pkg.Configuration, services pkg.ServiceMap) (*pkg.Deployment, error) {
+	var messageBus = make(pkg.MessageBus)
+
+	if output != outputJSON {
+		fmt.Printf("Using %s to deploy services.\n\n", util.White.Fg()+shortCommit(pkg.Config.Commit)+util.Reset)
+	}
+
+	deployment := pkg.NewDeployment(pkg.Config.Commit)
+
+	err := pkg.AuditDeployment(pkg.LocalActor(), action, deployment, services)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- deployment.Run(config, services, messageBus)
+	}()
+
+	render(services, messageBus)
+
+	return deployment, <-done
+}
+
+// render prints the messages of the deployment of the services until the channel is closed.
+func render(services pkg.ServiceMap, messages <-chan pkg.Message) {
+	if output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+
+		for message := range messages {
+			_ = encoder.Encode(message)
+		}
+
+		return
+	}
+
+	// the live view is only drawn on terminals supporting ansi escape codes
+	view := newDashboard(os.Stdout, services, util.AnsiEnabled && util.IsTerminal(os.Stdout))
+
+	var tick <-chan time.Time
+
+	if view.live {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	view.Draw()
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				view.Finish()
+
+				return
+			}
+
+			view.Update(message)
+		case <-tick:
+			view.Draw()
+		}
+	}
 }
 
 // NewDeployCommand creates and configures the services defined in the configuration
@@ -111,18 +318,56 @@ func NewDeployCommand() *cobra.Command {
 		Args:  cobra.RangeArgs(0, 1),
 	}
 
+	cmd.Flags().BoolVar(&plan, "plan", false, "print what would change without deploying")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "redeploy the services that are up to date")
+	cmd.Flags().StringArrayVarP(&servicePatterns, "service", "s", nil, "deploy only the services matching the pattern, may be repeated")
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "output format, text or json")
+
 	return cmd
 }
 
-func render(messages map[string]string) {
-	keys := make([]string, 0, len(messages))
-	for k := range messages {
-		keys = append(keys, k)
+func printPlan(config *pkg.Configuration, services pkg.ServiceMap) error {
+	changes, err := pkg.Plan(config)
+	if err != nil {
+		return err
+	}
+
+	if len(servicePatterns) > 0 {
+		var selected []pkg.Change
+
+		for _, change := range changes {
+			if _, ok := services[change.Service]; ok {
+				selected = append(selected, change)
+			}
+		}
+
+		changes = selected
 	}
 
-	sort.Strings(keys)
+	if output == outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(changes)
+	}
 
-	for _, k := range keys {
-		fmt.Printf("%s: %s\n", k, messages[k])
+	fmt.Printf("Comparing %s with the running services.\n\n", util.White.Fg()+shortCommit(pkg.Config.Commit)+util.Reset)
+
+	counts := make(map[string]int)
+
+	for _, change := range changes {
+		counts[change.Action]++
+
+		switch change.Action {
+		case pkg.ActionCreate:
+			fmt.Printf("  %s+ %s%s will be created\n", util.Green, change.Service, util.Reset)
+		case pkg.ActionUpdate:
+			fmt.Printf("  %s~ %s%s will be updated %s(%s)%s\n", util.Yellow, change.Service, util.Reset, util.Gray, strings.Join(change.Reasons, ", "), util.Reset)
+		case pkg.ActionRemove:
+			fmt.Printf("  %s- %s%s will be removed\n", util.Red, change.Service, util.Reset)
+		default:
+			fmt.Printf("  %s  %s unchanged%s\n", util.Gray, change.Service, util.Reset)
+		}
 	}
+
+	fmt.Printf("\nPlan: %d to create, %d to update, %d to remove.\n", counts[pkg.ActionCreate], counts[pkg.ActionUpdate], counts[pkg.ActionRemove])
+
+	return nil
 }
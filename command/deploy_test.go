@@ -0,0 +1,114 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/redwebcreation/nest/pkg"
+)
+
+func TestDaemonRequest(t *testing.T) {
+	defer func(previous *pkg.ConfigLocator) { pkg.Config = previous }(pkg.Config)
+	pkg.Config = &pkg.ConfigLocator{ConfigLocatorConfig: pkg.ConfigLocatorConfig{Commit: "abc"}}
+
+	dataset := []struct {
+		commit   string
+		expected string
+	}{
+		{"", "abc"},
+		{"def", "def"},
+	}
+
+	for _, d := range dataset {
+		request := daemonRequest(pkg.DeployRequest{Commit: d.commit, Services: []string{"api"}})
+
+		if request.Commit != d.expected || len(request.Services) != 1 {
+			t.Errorf("Expected the daemon to deploy commit %s, got %+v", d.expected, request)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	defer func(previous string) { output = previous }(output)
+	output = outputJSON
+
+	deployment := &pkg.Deployment{
+		ID: "1",
+		Services: map[string]*pkg.ServiceDeployment{
+			"api": {Status: pkg.StatusSucceeded},
+			"web": {Status: pkg.StatusFailed},
+		},
+	}
+
+	dataset := []struct {
+		deployment *pkg.Deployment
+		err        error
+		id         string
+		code       int
+		message    string
+	}{
+		{&pkg.Deployment{ID: "1", Services: map[string]*pkg.ServiceDeployment{"api": {Status: pkg.StatusSucceeded}}}, nil, "1", 0, ""},
+		{deployment, nil, "1", ExitPartialFailure, "1 of 2 services failed to deploy"},
+		{nil, nil, "", 0, ""},
+		{nil, fmt.Errorf("cycle detected"), "", 1, "cycle detected"},
+		{deployment, fmt.Errorf("pull failed"), "1", 1, "pull failed"},
+	}
+
+	for _, d := range dataset {
+		var out bytes.Buffer
+
+		err := printSummary(&out, deploySummary{Type: "summary", Commit: "abc"}, d.deployment, d.err)
+
+		if (err == nil) != (d.code == 0) {
+			t.Errorf("Expected the exit code to be %d, got %v", d.code, err)
+		}
+
+		var exitError *ExitError
+		if d.code > 1 && (!errors.As(err, &exitError) || exitError.Code != d.code) {
+			t.Errorf("Expected the exit code to be %d, got %v", d.code, err)
+		}
+
+		var summary struct {
+			Type       string `json:"type"`
+			Deployment string `json:"deployment"`
+			Commit     string `json:"commit"`
+			Error      string `json:"error"`
+			ExitCode   *int   `json:"exit_code"`
+		}
+
+		if err = json.Unmarshal(out.Bytes(), &summary); err != nil {
+			t.Fatalf("Expected the summary to be printed, got %s: %v", out.String(), err)
+		}
+
+		if summary.Type != "summary" || summary.Commit != "abc" || summary.Deployment != d.id {
+			t.Errorf("Expected the summary of deployment %s, got %s", d.id, out.String())
+		}
+
+		if summary.ExitCode == nil || *summary.ExitCode != d.code {
+			t.Errorf("Expected the exit code %d in the summary, got %s", d.code, out.String())
+		}
+
+		if summary.Error != d.message {
+			t.Errorf("Expected the error %q in the summary, got %q", d.message, summary.Error)
+		}
+	}
+}
+
+func TestPrintSummary_Text(t *testing.T) {
+	defer func(previous string) { output = previous }(output)
+	output = outputText
+
+	var out bytes.Buffer
+
+	err := printSummary(&out, deploySummary{}, nil, fmt.Errorf("cycle detected"))
+	if err == nil || err.Error() != "cycle detected" {
+		t.Errorf("Expected the error to be returned, got %v", err)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("Expected no summary in text mode, got %s", out.String())
+	}
+}
@@ -0,0 +1,54 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/redwebcreation/nest/util"
+)
+
+const (
+	// ExitPartialFailure is the exit code of a deployment where some of the services failed.
+	ExitPartialFailure = 2
+	// ExitTotalFailure is the exit code of a deployment where every service failed.
+	ExitTotalFailure = 3
+)
+
+// ExitError is an error that makes nest exit with a specific code, other errors exit with 1.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// deploymentError returns an ExitError if any service of the deployment failed.
+func deploymentError(deployment *pkg.Deployment) error {
+	var failed int
+
+	for _, service := range deployment.Services {
+		if service.Status != pkg.StatusSucceeded {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	code := ExitPartialFailure
+	if failed == len(deployment.Services) {
+		code = ExitTotalFailure
+	}
+
+	return &ExitError{
+		Code: code,
+		Err:  fmt.Errorf("%d of %d %s failed to deploy", failed, len(deployment.Services), util.Plural(len(deployment.Services), "service", "services")),
+	}
+}
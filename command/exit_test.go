@@ -0,0 +1,46 @@
+package command
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/redwebcreation/nest/pkg"
+)
+
+func TestDeploymentError(t *testing.T) {
+	deployment := func(statuses ...string) *pkg.Deployment {
+		d := &pkg.Deployment{Services: make(map[string]*pkg.ServiceDeployment)}
+
+		for i, status := range statuses {
+			d.Services[string(rune('a'+i))] = &pkg.ServiceDeployment{Status: status}
+		}
+
+		return d
+	}
+
+	dataset := []struct {
+		deployment *pkg.Deployment
+		code       int
+	}{
+		{deployment(pkg.StatusSucceeded, pkg.StatusSucceeded), 0},
+		{deployment(pkg.StatusSucceeded, pkg.StatusFailed), ExitPartialFailure},
+		{deployment(pkg.StatusFailed, pkg.StatusFailed), ExitTotalFailure},
+	}
+
+	for _, d := range dataset {
+		err := deploymentError(d.deployment)
+
+		if d.code == 0 {
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+
+			continue
+		}
+
+		var exitError *ExitError
+		if !errors.As(err, &exitError) || exitError.Code != d.code {
+			t.Errorf("Expected exit code %d, got %v", d.code, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/redwebcreation/nest/util"
+	"github.com/spf13/cobra"
+)
+
+var dryRun bool
+
+func runGcCommand(cmd *cobra.Command, args []string) error {
+	// the daemon collects when it runs, so that the collection does not race with its deployments
+	if !dryRun && pkg.DaemonRunning() {
+		return gcThroughDaemon()
+	}
+
+	config, err := pkg.Config.Retrieve()
+	if err != nil {
+		return err
+	}
+
+	stale, err := pkg.StaleContainers(config)
+	if err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		fmt.Println("No stale containers.")
+		return nil
+	}
+
+	if dryRun {
+		for _, c := range stale {
+			reason := "deployment " + c.DeploymentID
+			if c.Service == nil {
+				reason = "removed from the configuration"
+			}
+
+			fmt.Printf("%s %s(%s, %s)%s\n", c.Name, util.Gray, c.ServiceName, reason, util.Reset)
+		}
+
+		fmt.Printf("\n%d %s would be removed.\n", len(stale), util.Plural(len(stale), "container", "containers"))
+		return nil
+	}
+
+	var errors []string
+
+	for _, err = range pkg.CollectContainers(stale, func(c pkg.StaleContainer, event pkg.Event) {
+		fmt.Printf("%s: %s\n", c.ServiceName, event)
+	}) {
+		errors = append(errors, err.Error())
+	}
+
+	return printCollected(len(stale), errors)
+}
+
+// gcThroughDaemon asks the daemon to collect the stale containers and prints what it did.
+func gcThroughDaemon() error {
+	result, err := pkg.NewDaemonClient().Collect()
+	if err != nil {
+		return err
+	}
+
+	if result.Stale == 0 {
+		fmt.Println("No stale containers.")
+		return nil
+	}
+
+	for _, event := range result.Events {
+		fmt.Printf("%s: %s\n", event.Service, event.Message)
+	}
+
+	return printCollected(result.Stale, result.Errors)
+}
+
+// printCollected prints the outcome of the collection of the stale containers.
+func printCollected(stale int, errors []string) error {
+	for _, err := range errors {
+		_, _ = fmt.Fprintf(os.Stderr, "%s%s%s\n", util.Red, err, util.Reset)
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("could not collect %d %s", len(errors), util.Plural(len(errors), "container", "containers"))
+	}
+
+	fmt.Printf("\nCollected %d %s.\n", stale, util.Plural(stale, "container", "containers"))
+
+	return nil
+}
+
+// NewGcCommand removes the containers of previous deployments and of removed services
+func NewGcCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "remove stale containers",
+		RunE:  runGcCommand,
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the containers that would be removed")
+
+	return cmd
+}
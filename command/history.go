@@ -0,0 +1,143 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/redwebcreation/nest/util"
+	"github.com/spf13/cobra"
+)
+
+var historyLimit int
+
+func runHistoryCommand(cmd *cobra.Command, args []string) error {
+	history, err := pkg.LoadHistory()
+	if err != nil {
+		return err
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No deployments yet.")
+		return nil
+	}
+
+	if historyLimit > 0 && len(history) > historyLimit {
+		history = history[:historyLimit]
+	}
+
+	for _, deployment := range history {
+		fmt.Printf("%s%s%s  %s  %s  %s\n", util.White, deployment.ID, util.Reset, shortCommit(deployment.Commit), deployment.StartedAt.Format("2006-01-02 15:04:05"), duration(deployment))
+
+		if deployment.Error != "" {
+			fmt.Printf("  %s%s%s\n", util.Red, deployment.Error, util.Reset)
+		}
+
+		for _, name := range sortedServices(deployment) {
+			fmt.Printf("  %s\n", serviceResult(name, deployment.Services[name]))
+		}
+	}
+
+	return nil
+}
+
+func runHistoryShowCommand(cmd *cobra.Command, args []string) error {
+	deployment, err := pkg.LoadDeployment(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("deployment: %s\n", deployment.ID)
+	fmt.Printf("commit: %s\n", deployment.Commit)
+	fmt.Printf("started at: %s\n", deployment.StartedAt.Format(time.RFC1123))
+	if !deployment.FinishedAt.IsZero() {
+		fmt.Printf("finished at: %s\n", deployment.FinishedAt.Format(time.RFC1123))
+	}
+
+	fmt.Println()
+
+	for _, name := range sortedServices(deployment) {
+		fmt.Println(serviceResult(name, deployment.Services[name]))
+	}
+
+	fmt.Println()
+
+	for _, event := range deployment.Events {
+		fmt.Printf("%s%s%s %s: %s\n", util.Gray, event.Time.Format("15:04:05.000"), util.Reset, event.Service, event.Message)
+	}
+
+	return nil
+}
+
+func sortedServices(deployment *pkg.Deployment) []string {
+	names := make([]string, 0, len(deployment.Services))
+	for name := range deployment.Services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func serviceResult(name string, service *pkg.ServiceDeployment) string {
+	color := util.Gray
+
+	switch service.Status {
+	case pkg.StatusSucceeded:
+		color = util.Green
+	case pkg.StatusFailed:
+		color = util.Red
+	}
+
+	result := fmt.Sprintf("%s %s(%s)%s %s%s%s", name, util.Gray, service.Image, util.Reset, color, service.Status, util.Reset)
+
+	if service.RolledBack {
+		result += " " + util.Yellow.Fg() + "rolled back" + util.Reset
+	}
+
+	if service.Error != "" {
+		result += ": " + service.Error
+	}
+
+	return result
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+
+	return commit
+}
+
+func duration(deployment *pkg.Deployment) string {
+	if deployment.FinishedAt.IsZero() {
+		return "unfinished"
+	}
+
+	return deployment.FinishedAt.Sub(deployment.StartedAt).Round(time.Millisecond).String()
+}
+
+// NewHistoryCommand lists the past deployments
+func NewHistoryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "list past deployments",
+		RunE:  runHistoryCommand,
+	}
+
+	cmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "number of deployments to list, 0 lists all of them")
+
+	show := &cobra.Command{
+		Use:   "show <id>",
+		Short: "print the events of a deployment",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runHistoryShowCommand,
+	}
+
+	cmd.AddCommand(show)
+
+	return cmd
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme"
+)
+
+func runProxyCommand(cmd *cobra.Command, args []string) error {
+	config, err := pkg.Config.Retrieve()
+	if err != nil {
+		return err
+	}
+
+	proxy := pkg.NewProxy(config)
+
+	certificates, err := pkg.NewCertificateManager(config)
+	if err != nil {
+		return err
+	}
+
+	failures := make(chan error)
+
+	serveProxy(config, proxy, certificates, failures)
+
+	fmt.Printf("Proxy listening on %s and %s\n", config.Proxy.HTTP, config.Proxy.HTTPS)
+
+	return <-failures
+}
+
+// serveProxy obtains the certificates and serves the proxy over http and https in the background.
+// The errors stopping the servers are sent to the channel.
+func serveProxy(config *pkg.Configuration, proxy *pkg.Proxy, certificates *pkg.CertificateManager, failures chan<- error) {
+	go certificates.Run(context.Background(), func(group []string, err error) {
+		_, _ = fmt.Fprintf(os.Stderr, "could not obtain a certificate for %s: %s\n", strings.Join(group, ", "), err)
+	})
+
+	go func() {
+		failures <- http.ListenAndServe(config.Proxy.HTTP, certificates.HTTPHandler(proxy))
+	}()
+
+	go func() {
+		server := &http.Server{
+			Addr:    config.Proxy.HTTPS,
+			Handler: proxy,
+			TLSConfig: &tls.Config{
+				GetCertificate: certificates.GetCertificate,
+				NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+				MinVersion:     tls.VersionTLS12,
+			},
+		}
+
+		failures <- server.ListenAndServeTLS("", "")
+	}()
+}
+
+// NewProxyCommand forwards incoming requests to the services based on their hosts
+func NewProxyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "run the reverse proxy",
+		RunE:  runProxyCommand,
+	}
+
+	return cmd
+}
@@ -0,0 +1,141 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/spf13/cobra"
+)
+
+func runRollbackCommand(cmd *cobra.Command, args []string) error {
+	// the daemon rolls back when it runs, so that the rollback does not race with its deployments
+	if pkg.DaemonRunning() {
+		return rollbackThroughDaemon(pkg.RollbackRequest{Services: args})
+	}
+
+	config, err := pkg.Config.Retrieve()
+	if err != nil {
+		return err
+	}
+
+	history, err := pkg.LoadHistory()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+
+	if len(args) == 1 {
+		names = append(names, args[0])
+	} else {
+		for name := range config.Services {
+			names = append(names, name)
+		}
+	}
+
+	rollbacks, skipped := history.PlanRollbacks(names)
+
+	if len(args) == 1 && len(skipped) > 0 {
+		return fmt.Errorf("no previous successful deployment of %s", args[0])
+	}
+
+	printSkipped(skipped)
+
+	if len(rollbacks) == 0 {
+		return pkg.ErrNothingToRollBack
+	}
+
+	// the services that could not be rolled back, the other commits are still rolled back
+	var failure error
+
+	for _, rollback := range rollbacks {
+		err = pkg.LoadConfigFromCommit(rollback.Commit)
+		if err != nil {
+			return err
+		}
+
+		restored, err := pkg.Config.Retrieve()
+		if err != nil {
+			return err
+		}
+
+		services := make(pkg.ServiceMap, len(rollback.Services))
+
+		for _, name := range rollback.Services {
+			service, ok := restored.Services[name]
+			if !ok {
+				return fmt.Errorf("service %s does not exist at commit %s", name, shortCommit(rollback.Commit))
+			}
+
+			services[name] = service
+		}
+
+		// a rollback restores services, the services removed from the configuration are left to the next deployment
+		deployment, err := deploy("rollback", nil, services)
+		if err != nil {
+			return err
+		}
+
+		if err = deploymentError(deployment); err != nil {
+			failure = err
+		}
+
+		err = rollback.MarkRolledBack(deployment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return failure
+}
+
+// rollbackThroughDaemon asks the daemon to roll back and prints the progress of each deployment until they finish.
+func rollbackThroughDaemon(request pkg.RollbackRequest) error {
+	client := pkg.NewDaemonClient()
+
+	result, err := client.Rollback(request)
+	if err != nil {
+		return err
+	}
+
+	printSkipped(result.Skipped)
+
+	// the services that could not be rolled back, the other commits are still rolled back
+	var failure error
+
+	for _, deployment := range result.Deployments {
+		err = followThroughDaemon(client, deployment)
+
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) {
+			failure = err
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return failure
+}
+
+// printSkipped prints the services that can not be rolled back.
+func printSkipped(skipped []string) {
+	for _, name := range skipped {
+		fmt.Printf("Skipping %s, there is no previous successful deployment.\n", name)
+	}
+}
+
+// NewRollbackCommand redeploys the previous successful deployment of a service or of the whole configuration
+func NewRollbackCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback [service]",
+		Short: "redeploy the previous successful deployment",
+		Args:  cobra.RangeArgs(0, 1),
+		RunE:  runRollbackCommand,
+	}
+
+	return cmd
+}
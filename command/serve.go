@@ -0,0 +1,67 @@
+package command
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/spf13/cobra"
+)
+
+var listen string
+var token string
+var webhookSecret string
+
+func runServeCommand(cmd *cobra.Command, args []string) error {
+	err := loadAPISecrets()
+	if err != nil {
+		return err
+	}
+
+	server := pkg.NewServer(token, webhookSecret)
+
+	fmt.Printf("API listening on %s\n", listen)
+
+	return http.ListenAndServe(listen, server.Handler())
+}
+
+// loadAPISecrets reads the token and the webhook secret from the environment unless set by the flags.
+// Without a token, at least one token must have been created.
+func loadAPISecrets() error {
+	if token == "" {
+		token = os.Getenv("NEST_API_TOKEN")
+	}
+
+	if token == "" {
+		tokens, err := pkg.LoadAccessTokens()
+		if err != nil {
+			return err
+		}
+
+		if len(tokens) == 0 {
+			return fmt.Errorf("create a token with `nest token create` or set one with --token or the NEST_API_TOKEN environment variable")
+		}
+	}
+
+	if webhookSecret == "" {
+		webhookSecret = os.Getenv("NEST_WEBHOOK_SECRET")
+	}
+
+	return nil
+}
+
+// NewServeCommand exposes the deployments through an HTTP API
+func NewServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "serve the deployment API",
+		RunE:  runServeCommand,
+	}
+
+	cmd.Flags().StringVarP(&listen, "listen", "l", "127.0.0.1:8000", "address the API listens on")
+	cmd.Flags().StringVar(&token, "token", "", "token authenticating the requests with every scope, defaults to $NEST_API_TOKEN")
+	cmd.Flags().StringVar(&webhookSecret, "webhook-secret", "", "secret signing the push webhooks of the git provider, defaults to $NEST_WEBHOOK_SECRET")
+
+	return cmd
+}
@@ -0,0 +1,76 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/redwebcreation/nest/util"
+	"github.com/spf13/cobra"
+)
+
+func runStatusCommand(cmd *cobra.Command, args []string) error {
+	var statuses []pkg.ServiceStatus
+	var err error
+
+	if pkg.DaemonRunning() {
+		fmt.Printf("daemon: %srunning%s\n\n", util.Green, util.Reset)
+
+		statuses, err = pkg.NewDaemonClient().Services()
+	} else {
+		fmt.Printf("daemon: %snot running%s\n\n", util.Gray, util.Reset)
+
+		var config *pkg.Configuration
+
+		config, err = pkg.Config.Retrieve()
+		if err != nil {
+			return err
+		}
+
+		statuses, err = pkg.ServiceStatuses(config)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, service := range statuses {
+		running := 0
+
+		for _, c := range service.Containers {
+			if c.Routed && c.State == "running" {
+				running++
+			}
+		}
+
+		color := util.Green
+		if running < service.Replicas {
+			color = util.Red
+		}
+
+		fmt.Printf("%s %s(%s)%s %s%d/%d running%s %s\n", service.Name, util.Gray, service.Image, util.Reset, color, running, service.Replicas, util.Reset, strings.Join(service.Hosts, ", "))
+
+		for _, c := range service.Containers {
+			routed := ""
+			if c.Routed {
+				routed = " routed"
+			}
+
+			fmt.Printf("  %s %s(%s, deployment %s)%s%s\n", c.Name, util.Gray, c.State, c.DeploymentID, util.Reset, routed)
+		}
+	}
+
+	return nil
+}
+
+// NewStatusCommand prints the services and their containers
+func NewStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "print the status of the services",
+		Args:  cobra.NoArgs,
+		RunE:  runStatusCommand,
+	}
+
+	return cmd
+}
@@ -0,0 +1,171 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redwebcreation/nest/pkg"
+	"github.com/redwebcreation/nest/util"
+	"github.com/spf13/cobra"
+)
+
+var tokenScope string
+var tokenServices []string
+var tokenExpiresIn string
+
+func runTokenCreateCommand(cmd *cobra.Command, args []string) error {
+	ttl, err := parseExpiry(tokenExpiresIn)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := pkg.LoadAccessTokens()
+	if err != nil {
+		return err
+	}
+
+	secret, token, err := tokens.Create(tokenScope, tokenServices, ttl)
+	if err != nil {
+		return err
+	}
+
+	err = tokens.Save()
+	if err != nil {
+		return err
+	}
+
+	err = pkg.Audit(pkg.AuditEntry{
+		Actor:    pkg.LocalActor(),
+		Action:   "token create " + token.ID,
+		Services: token.Services,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created token %s, copy it now as it will not be shown again:\n\n", token.ID)
+	fmt.Println(util.White.Fg() + secret + util.Reset)
+
+	return nil
+}
+
+func runTokenListCommand(cmd *cobra.Command, args []string) error {
+	tokens, err := pkg.LoadAccessTokens()
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		fmt.Println("No tokens yet, create one with `nest token create`.")
+		return nil
+	}
+
+	for _, token := range tokens {
+		services := "all services"
+		if len(token.Services) > 0 {
+			services = strings.Join(token.Services, ", ")
+		}
+
+		expiry := "never expires"
+		if token.Expired() {
+			expiry = util.Red.Fg() + "expired" + util.Reset
+		} else if token.ExpiresAt != nil {
+			expiry = "expires " + token.ExpiresAt.Format("2006-01-02 15:04")
+		}
+
+		fmt.Printf("%s%s%s  %s  %s  created %s, %s\n", util.White, token.ID, util.Reset, token.Scope, services, token.CreatedAt.Format("2006-01-02 15:04"), expiry)
+	}
+
+	return nil
+}
+
+func runTokenRevokeCommand(cmd *cobra.Command, args []string) error {
+	tokens, err := pkg.LoadAccessTokens()
+	if err != nil {
+		return err
+	}
+
+	err = tokens.Revoke(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = tokens.Save()
+	if err != nil {
+		return err
+	}
+
+	err = pkg.Audit(pkg.AuditEntry{
+		Actor:  pkg.LocalActor(),
+		Action: "token revoke " + args[0],
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Revoked token %s.\n", args[0])
+
+	return nil
+}
+
+// parseExpiry parses a duration such as 90d or 12h, the token never expires if it is never or 0.
+func parseExpiry(raw string) (time.Duration, error) {
+	if raw == "never" || raw == "0" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(raw, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(raw, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid expiry %s", raw)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid expiry %s", raw)
+	}
+
+	return ttl, nil
+}
+
+// NewTokenCommand manages the tokens authenticating the requests to the API
+func NewTokenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "manage the API tokens",
+	}
+
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "create a token",
+		Args:  cobra.NoArgs,
+		RunE:  runTokenCreateCommand,
+	}
+
+	create.Flags().StringVar(&tokenScope, "scope", pkg.ScopeRead, "scope of the token, deploy:read or deploy:write")
+	create.Flags().StringArrayVarP(&tokenServices, "service", "s", nil, "restrict the token to the services matching the pattern, may be repeated")
+	create.Flags().StringVar(&tokenExpiresIn, "expires-in", "90d", "lifetime of the token such as 30d or 12h, never for a token that does not expire")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "list the tokens",
+		Args:  cobra.NoArgs,
+		RunE:  runTokenListCommand,
+	}
+
+	revoke := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "revoke a token",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runTokenRevokeCommand,
+	}
+
+	cmd.AddCommand(create, list, revoke)
+
+	return cmd
+}
@@ -2,9 +2,18 @@ package docker
 
 import (
 	"context"
+	"fmt"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/redwebcreation/nest/global"
+	"io"
+)
+
+var (
+	// ErrNoIPAddress is returned when the container is not attached to any network
+	ErrNoIPAddress = fmt.Errorf("container has no ip address")
 )
 
 func GetNestContainers() ([]types.Container, error) {
@@ -13,10 +22,159 @@ func GetNestContainers() ([]types.Container, error) {
 		Filters: filters.NewArgs(
 			filters.KeyValuePair{
 				Key:   "label",
-				Value: "nest:container=true",
+				Value: "cloud.usenest.service",
 			},
 		),
 	})
 
 	return containers, err
 }
+
+// GetServiceContainers returns the containers deployed for the given service, running or not.
+func GetServiceContainers(service string) ([]types.Container, error) {
+	containers, err := global.Docker.ContainerList(context.Background(), types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.KeyValuePair{
+				Key:   "label",
+				Value: "cloud.usenest.service=" + service,
+			},
+		),
+	})
+
+	return containers, err
+}
+
+// IPAddress returns the first IP address the container can be reached at.
+func IPAddress(c types.Container) string {
+	if c.NetworkSettings == nil {
+		return ""
+	}
+
+	for _, endpoint := range c.NetworkSettings.Networks {
+		if endpoint.IPAddress != "" {
+			return endpoint.IPAddress
+		}
+	}
+
+	return ""
+}
+
+// RemoveContainer stops and removes the container.
+func RemoveContainer(id string) error {
+	err := global.Docker.ContainerStop(context.Background(), id, nil)
+	if err != nil {
+		return err
+	}
+
+	return global.Docker.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{})
+}
+
+// InspectIPAddress returns the first IP address the container can be reached at.
+func InspectIPAddress(id string) (string, error) {
+	c, err := global.Docker.ContainerInspect(context.Background(), id)
+	if err != nil {
+		return "", err
+	}
+
+	if c.NetworkSettings != nil {
+		for _, endpoint := range c.NetworkSettings.Networks {
+			if endpoint.IPAddress != "" {
+				return endpoint.IPAddress, nil
+			}
+		}
+	}
+
+	return "", ErrNoIPAddress
+}
+
+// Exec runs the command inside the container and writes its output to w.
+func Exec(ctx context.Context, id string, command []string, w io.Writer) (int, error) {
+	ref, err := global.Docker.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          command,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	attach, err := global.Docker.ContainerExecAttach(ctx, ref.ID, types.ExecStartCheck{})
+	if err != nil {
+		return 0, err
+	}
+	defer attach.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := stdcopy.StdCopy(w, w, attach.Reader)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			return 0, err
+		}
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	inspect, err := global.Docker.ContainerExecInspect(ctx, ref.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	return inspect.ExitCode, nil
+}
+
+// Run creates a container from the configuration, runs it and removes the container once it exited.
+// The output of the container is written to w.
+func Run(ctx context.Context, config *container.Config, host *container.HostConfig, w io.Writer) (int, error) {
+	c, err := global.Docker.ContainerCreate(ctx, config, host, nil, nil, "")
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = global.Docker.ContainerRemove(context.Background(), c.ID, types.ContainerRemoveOptions{
+			Force: true,
+		})
+	}()
+
+	attach, err := global.Docker.ContainerAttach(ctx, c.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer attach.Close()
+
+	statuses, errs := global.Docker.ContainerWait(ctx, c.ID, container.WaitConditionNextExit)
+
+	err = global.Docker.ContainerStart(ctx, c.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	copied := make(chan error, 1)
+
+	go func() {
+		_, err := stdcopy.StdCopy(w, w, attach.Reader)
+		copied <- err
+	}()
+
+	select {
+	case status := <-statuses:
+		if err = <-copied; err != nil {
+			return 0, err
+		}
+
+		return int(status.StatusCode), nil
+	case err = <-errs:
+		return 0, err
+	}
+}
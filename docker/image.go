@@ -23,6 +23,8 @@ func (i Image) String() string {
 }
 
 type PullEvent struct {
+	// ID of the layer the event is about, if any.
+	ID             string `json:"id"`
 	Status         string `json:"status"`
 	Error          string `json:"error"`
 	Progress       string `json:"progress"`
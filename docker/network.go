@@ -0,0 +1,38 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/redwebcreation/nest/global"
+)
+
+// EnsureNetwork creates the bridge network with the given labels unless it already exists.
+// It is safe to call concurrently for the same network, only one of the callers creates it.
+func EnsureNetwork(name string, labels map[string]string) (bool, error) {
+	_, err := global.Docker.NetworkInspect(context.Background(), name, types.NetworkInspectOptions{})
+	if err == nil {
+		return false, nil
+	}
+
+	if !client.IsErrNotFound(err) {
+		return false, err
+	}
+
+	_, err = global.Docker.NetworkCreate(context.Background(), name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         labels,
+	})
+	if err != nil {
+		// the services sharing the network are deployed concurrently, another deployment may have just created it
+		if _, inspectErr := global.Docker.NetworkInspect(context.Background(), name, types.NetworkInspectOptions{}); inspectErr == nil {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,31 @@
+package docker
+
+import (
+	"context"
+
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/redwebcreation/nest/global"
+)
+
+// EnsureVolume creates the volume with the given labels unless it already exists.
+func EnsureVolume(name string, labels map[string]string) (bool, error) {
+	_, err := global.Docker.VolumeInspect(context.Background(), name)
+	if err == nil {
+		return false, nil
+	}
+
+	if !client.IsErrNotFound(err) {
+		return false, err
+	}
+
+	_, err = global.Docker.VolumeCreate(context.Background(), volumetypes.VolumeCreateBody{
+		Name:   name,
+		Labels: labels,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
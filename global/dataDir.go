@@ -0,0 +1,14 @@
+package global
+
+import "github.com/mitchellh/go-homedir"
+
+// DataDir is where nest stores the state it needs across runs.
+var DataDir string
+
+func init() {
+	home, err := homedir.Dir()
+	if err != nil {
+		panic(err)
+	}
+	DataDir = home + "/.nest"
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/redwebcreation/nest/command"
 	"github.com/redwebcreation/nest/global"
@@ -13,14 +14,22 @@ import (
 
 var commands = []*cobra.Command{
 	command.NewDeployCommand(),
+	command.NewRollbackCommand(),
 	command.NewMedicCommand(),
 	command.NewConfigCommand(),
+	command.NewProxyCommand(),
+	command.NewGcCommand(),
+	command.NewServeCommand(),
+	command.NewDaemonCommand(),
+	command.NewStatusCommand(),
 }
 
 var standalone = []*cobra.Command{
 	command.NewConfigureCommand(),
 	command.NewVersionCommand(),
 	command.NewSelfUpdateCommand(),
+	command.NewHistoryCommand(),
+	command.NewTokenCommand(),
 }
 
 var nest = &cobra.Command{
@@ -75,6 +84,12 @@ func main() {
 	err := nest.Execute()
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, "error: "+err.Error())
+
+		var exitError *command.ExitError
+		if errors.As(err, &exitError) {
+			os.Exit(exitError.Code)
+		}
+
 		os.Exit(1)
 	}
 }
@@ -85,6 +100,9 @@ func init() {
 		os.Exit(1)
 	}
 
+	// read by the util package before the flags are parsed
+	nest.PersistentFlags().Bool("no-ansi", false, "disable colors and the live deploy view")
+
 	nest.SetHelpCommand(&cobra.Command{
 		Use:    "_help",
 		Hidden: true,
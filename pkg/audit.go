@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+// AuditEntry is a line of the audit log, recording who did what.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor is who acted, such as user:alice from the CLI, token:<id> or webhook:github from the API.
+	Actor string `json:"actor"`
+	// Action is either deploy, rollback or the method and path of an API request.
+	Action string `json:"action"`
+	// Status is the status code of the response to an API request.
+	Status     int      `json:"status,omitempty"`
+	RemoteAddr string   `json:"remote_addr,omitempty"`
+	Deployment string   `json:"deployment,omitempty"`
+	Commit     string   `json:"commit,omitempty"`
+	Services   []string `json:"services,omitempty"`
+}
+
+// auditMu serializes the writes to the audit log.
+var auditMu sync.Mutex
+
+func auditLogPath() string {
+	return global.DataDir + "/audit.log"
+}
+
+// Audit appends the entry to the audit log as a line of JSON.
+func Audit(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	err = os.MkdirAll(global.DataDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(auditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(contents, '\n'))
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// AuditDeployment records that the actor started the deployment of the services.
+func AuditDeployment(actor string, action string, deployment *Deployment, services ServiceMap) error {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return Audit(AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		Deployment: deployment.ID,
+		Commit:     deployment.Commit,
+		Services:   names,
+	})
+}
+
+// LocalActor identifies the user running nest, the user who ran sudo if nest runs through sudo.
+func LocalActor() string {
+	if sudoer := os.Getenv("SUDO_USER"); sudoer != "" {
+		return "user:" + sudoer
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return "user:unknown"
+	}
+
+	return "user:" + current.Username
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	RoundRobin       = "round_robin"
+	LeastConnections = "least_connections"
+)
+
+// unhealthyPeriod is how long a replica that failed to answer a request is skipped.
+const unhealthyPeriod = 10 * time.Second
+
+// Balancer picks the replica each request of a service is forwarded to.
+type Balancer struct {
+	// Strategy is either RoundRobin or LeastConnections.
+	Strategy string
+
+	mu          sync.Mutex
+	next        int
+	connections map[string]int
+	unhealthy   map[string]time.Time
+}
+
+func NewBalancer(strategy string) *Balancer {
+	return &Balancer{
+		Strategy:    strategy,
+		connections: make(map[string]int),
+		unhealthy:   make(map[string]time.Time),
+	}
+}
+
+// Pick returns the replica the next request is forwarded to.
+// Replicas marked as unhealthy are skipped unless none of them is healthy.
+func (b *Balancer) Pick(upstreams []*url.URL) *url.URL {
+	if len(upstreams) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	healthy := make([]*url.URL, 0, len(upstreams))
+	for _, u := range upstreams {
+		if now.After(b.unhealthy[u.Host]) {
+			healthy = append(healthy, u)
+		}
+	}
+
+	if len(healthy) == 0 {
+		healthy = upstreams
+	}
+
+	// replicas with as many connections as the best one are picked in turn
+	start := b.next % len(healthy)
+	b.next++
+
+	if b.Strategy != LeastConnections {
+		return healthy[start]
+	}
+
+	best := healthy[start]
+
+	for i := 1; i < len(healthy); i++ {
+		u := healthy[(start+i)%len(healthy)]
+
+		if b.connections[u.Host] < b.connections[best.Host] {
+			best = u
+		}
+	}
+
+	return best
+}
+
+// Acquire counts a request in flight to the replica, it must be followed by a call to Release.
+func (b *Balancer) Acquire(u *url.URL) {
+	b.mu.Lock()
+	b.connections[u.Host]++
+	b.mu.Unlock()
+}
+
+func (b *Balancer) Release(u *url.URL) {
+	b.mu.Lock()
+	b.connections[u.Host]--
+	if b.connections[u.Host] <= 0 {
+		delete(b.connections, u.Host)
+	}
+	b.mu.Unlock()
+}
+
+// MarkUnhealthy skips the replica for the unhealthy period.
+func (b *Balancer) MarkUnhealthy(u *url.URL) {
+	b.mu.Lock()
+	b.unhealthy[u.Host] = time.Now().Add(unhealthyPeriod)
+	b.mu.Unlock()
+}
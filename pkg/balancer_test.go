@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"net/url"
+	"testing"
+)
+
+func upstreamsFor(hosts ...string) []*url.URL {
+	var upstreams []*url.URL
+
+	for _, host := range hosts {
+		upstreams = append(upstreams, &url.URL{Scheme: "http", Host: host})
+	}
+
+	return upstreams
+}
+
+func TestBalancer_RoundRobin(t *testing.T) {
+	balancer := NewBalancer(RoundRobin)
+	upstreams := upstreamsFor("10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80")
+
+	for i := 0; i < 6; i++ {
+		u := balancer.Pick(upstreams)
+
+		if u.Host != upstreams[i%3].Host {
+			t.Errorf("Expected request %d to go to %s, got %s", i, upstreams[i%3].Host, u.Host)
+		}
+	}
+}
+
+func TestBalancer_LeastConnections(t *testing.T) {
+	balancer := NewBalancer(LeastConnections)
+	upstreams := upstreamsFor("10.0.0.1:80", "10.0.0.2:80")
+
+	balancer.Acquire(upstreams[0])
+	balancer.Acquire(upstreams[0])
+	balancer.Acquire(upstreams[1])
+
+	for i := 0; i < 3; i++ {
+		if u := balancer.Pick(upstreams); u.Host != upstreams[1].Host {
+			t.Errorf("Expected the replica with the fewest connections, got %s", u.Host)
+		}
+	}
+
+	balancer.Release(upstreams[0])
+	balancer.Release(upstreams[0])
+
+	if u := balancer.Pick(upstreams); u.Host != upstreams[0].Host {
+		t.Errorf("Expected the replica without connections, got %s", u.Host)
+	}
+}
+
+func TestBalancer_MarkUnhealthy(t *testing.T) {
+	balancer := NewBalancer(RoundRobin)
+	upstreams := upstreamsFor("10.0.0.1:80", "10.0.0.2:80")
+
+	balancer.MarkUnhealthy(upstreams[0])
+
+	for i := 0; i < 4; i++ {
+		if u := balancer.Pick(upstreams); u.Host != upstreams[1].Host {
+			t.Errorf("Expected the unhealthy replica to be skipped, got %s", u.Host)
+		}
+	}
+
+	balancer.MarkUnhealthy(upstreams[1])
+
+	if u := balancer.Pick(upstreams); u == nil {
+		t.Error("Expected a replica to be picked when none of them is healthy")
+	}
+
+	if u := balancer.Pick(nil); u != nil {
+		t.Errorf("Expected no replica, got %s", u.Host)
+	}
+}
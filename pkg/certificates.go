@@ -0,0 +1,555 @@
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	ErrUnknownHost           = fmt.Errorf("no service accepts this host")
+	ErrNoSupportedChallenges = fmt.Errorf("the certificate authority offered no supported challenges")
+)
+
+// renewBefore is how long before its expiration a certificate gets renewed.
+const renewBefore = 30 * 24 * time.Hour
+
+// renewInterval is how often certificates are checked for renewal.
+const renewInterval = 12 * time.Hour
+
+// retryAfter is how long a group waits after its first failed order, the wait doubles with every failure up to renewInterval.
+// Handshakes do not start new orders meanwhile, they would hit the rate limits of the certificate authority.
+const retryAfter = 5 * time.Minute
+
+// CertificateManager obtains and renews certificates for the services' hosts through ACME.
+type CertificateManager struct {
+	// Client talks to the ACME directory.
+	Client *acme.Client
+	// Email to register the account with.
+	Email string
+	// Dir is where the account key and the certificates are stored.
+	Dir string
+	// Groups are the hosts to obtain certificates for, each group shares a certificate.
+	// They are guarded by mu once the manager runs.
+	Groups [][]string
+
+	// registering prevents the account to be registered concurrently.
+	registering sync.Mutex
+
+	mu           sync.RWMutex
+	certificates map[string]*tls.Certificate
+	tokens       map[string]string
+	challenges   map[string]*tls.Certificate
+	// obtaining prevents the certificate of a group to be requested concurrently, groups are keyed by their first host.
+	obtaining map[string]*sync.Mutex
+	failures  map[string]*issuanceFailure
+}
+
+// issuanceFailure is the last failed order of a group.
+type issuanceFailure struct {
+	err      error
+	attempts int
+	retryAt  time.Time
+}
+
+func NewCertificateManager(config *Configuration) (*CertificateManager, error) {
+	directory, err := url.Parse(config.Proxy.Acme.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &CertificateManager{
+		Client: &acme.Client{
+			DirectoryURL: config.Proxy.Acme.Directory,
+		},
+		Email:        config.Proxy.Acme.Email,
+		Dir:          global.DataDir + "/certificates/" + directory.Host,
+		certificates: make(map[string]*tls.Certificate),
+		tokens:       make(map[string]string),
+		challenges:   make(map[string]*tls.Certificate),
+		obtaining:    make(map[string]*sync.Mutex),
+		failures:     make(map[string]*issuanceFailure),
+		Groups:       certificateGroups(config),
+	}
+
+	return m, nil
+}
+
+// certificateGroups returns the groups of hosts of the services that need a certificate.
+func certificateGroups(config *Configuration) [][]string {
+	var groups [][]string
+
+	for _, service := range config.Services {
+		for _, group := range service.HostGroups {
+			// wildcard certificates can not be obtained through http-01 or tls-alpn-01
+			if strings.Contains(strings.Join(group, ""), "*") {
+				continue
+			}
+
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// Reload replaces the hosts to obtain certificates for, the certificates of new hosts are obtained on demand.
+func (m *CertificateManager) Reload(config *Configuration) {
+	groups := certificateGroups(config)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Groups = groups
+}
+
+func (m *CertificateManager) groups() [][]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.Groups
+}
+
+// Run obtains the missing certificates and renews the expiring ones until the context is cancelled.
+func (m *CertificateManager) Run(ctx context.Context, onError func(group []string, err error)) {
+	for {
+		for _, group := range m.groups() {
+			if _, err := m.certificate(ctx, group); err != nil {
+				onError(group, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(renewInterval):
+		}
+	}
+}
+
+// GetCertificate is meant to be used as tls.Config.GetCertificate.
+func (m *CertificateManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	for _, proto := range hello.SupportedProtos {
+		if proto != acme.ALPNProto {
+			continue
+		}
+
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		if cert, ok := m.challenges[name]; ok {
+			return cert, nil
+		}
+
+		return nil, fmt.Errorf("no tls-alpn-01 challenge pending for %s", name)
+	}
+
+	for _, group := range m.groups() {
+		for _, host := range group {
+			if host != name {
+				continue
+			}
+
+			if cert := m.cached(group); cert != nil {
+				return cert, nil
+			}
+
+			cert, err := m.certificate(hello.Context(), group)
+			if cert != nil {
+				return cert, nil
+			}
+
+			return nil, err
+		}
+	}
+
+	return nil, ErrUnknownHost
+}
+
+// HTTPHandler answers the http-01 challenges and passes any other request to the fallback handler.
+func (m *CertificateManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		m.mu.RLock()
+		response, ok := m.tokens[r.URL.Path]
+		m.mu.RUnlock()
+
+		if !ok {
+			http.Error(w, "unknown challenge", http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(response))
+	})
+}
+
+// certificate returns the certificate of the group, obtaining a new one if it is missing or expires soon.
+// If the renewal fails, the current certificate is returned alongside the error as long as it has not expired.
+// A group whose last order failed is not ordered again before its retry time, the error of the last order is returned instead.
+func (m *CertificateManager) certificate(ctx context.Context, group []string) (*tls.Certificate, error) {
+	lock := m.groupLock(group)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cert := m.cached(group)
+	if cert == nil {
+		cert, _ = m.load(group)
+	}
+
+	if cert == nil || cert.Leaf.NotAfter.Before(time.Now().Add(renewBefore)) {
+		err := m.backoff(group)
+
+		var renewed *tls.Certificate
+		if err == nil {
+			renewed, err = m.obtain(ctx, group)
+			m.recordOrder(group, err)
+		}
+
+		if err != nil {
+			if cert == nil || time.Now().After(cert.Leaf.NotAfter) {
+				return nil, err
+			}
+
+			m.store(group, cert)
+
+			return cert, err
+		}
+
+		cert = renewed
+	}
+
+	m.store(group, cert)
+
+	return cert, nil
+}
+
+// groupLock returns the lock held while the certificate of the group is obtained.
+func (m *CertificateManager) groupLock(group []string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.obtaining[group[0]]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.obtaining[group[0]] = lock
+	}
+
+	return lock
+}
+
+// backoff returns the error of the last order of the group until it may be ordered again.
+func (m *CertificateManager) backoff(group []string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	failure, ok := m.failures[group[0]]
+	if !ok || time.Now().After(failure.retryAt) {
+		return nil
+	}
+
+	return fmt.Errorf("%w (retrying after %s)", failure.err, failure.retryAt.Format(time.RFC3339))
+}
+
+// recordOrder remembers whether the order of the group failed to delay the next one.
+func (m *CertificateManager) recordOrder(group []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		delete(m.failures, group[0])
+		return
+	}
+
+	failure, ok := m.failures[group[0]]
+	if !ok {
+		failure = &issuanceFailure{}
+		m.failures[group[0]] = failure
+	}
+
+	failure.err = err
+	failure.attempts++
+
+	wait := retryAfter
+	for i := 1; i < failure.attempts && wait < renewInterval; i++ {
+		wait *= 2
+	}
+
+	if wait > renewInterval {
+		wait = renewInterval
+	}
+
+	failure.retryAt = time.Now().Add(wait)
+}
+
+// cached returns the certificate of the group kept in memory if it has not expired.
+func (m *CertificateManager) cached(group []string) *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cert, ok := m.certificates[group[0]]
+	if !ok || time.Now().After(cert.Leaf.NotAfter) {
+		return nil
+	}
+
+	return cert
+}
+
+func (m *CertificateManager) store(group []string, cert *tls.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, host := range group {
+		m.certificates[host] = cert
+	}
+}
+
+func (m *CertificateManager) path(group []string) string {
+	return m.Dir + "/" + group[0] + ".pem"
+}
+
+func (m *CertificateManager) load(group []string) (*tls.Certificate, error) {
+	contents, err := os.ReadFile(m.path(group))
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(contents, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// the hosts of the group may have changed since the certificate was obtained
+	for _, host := range group {
+		if err = cert.Leaf.VerifyHostname(host); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cert, nil
+}
+
+func (m *CertificateManager) obtain(ctx context.Context, group []string) (*tls.Certificate, error) {
+	if err := m.register(ctx); err != nil {
+		return nil, err
+	}
+
+	order, err := m.Client.AuthorizeOrder(ctx, acme.DomainIDs(group...))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err = m.authorize(ctx, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = m.Client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: group[0]},
+		DNSNames: group,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, _, err := m.Client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey})
+	for _, der := range chain {
+		contents = append(contents, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	if err = os.WriteFile(m.path(group), contents, 0600); err != nil {
+		return nil, err
+	}
+
+	return m.load(group)
+}
+
+// authorize fulfills the first challenge nest supports for the authorization.
+func (m *CertificateManager) authorize(ctx context.Context, authzURL string) error {
+	authz, err := m.Client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" || c.Type == "tls-alpn-01" {
+			challenge = c
+			break
+		}
+	}
+
+	if challenge == nil {
+		return ErrNoSupportedChallenges
+	}
+
+	cleanup, err := m.fulfill(challenge, authz.Identifier.Value)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err = m.Client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+
+	_, err = m.Client.WaitAuthorization(ctx, authz.URI)
+
+	return err
+}
+
+// fulfill makes the challenge response available to the certificate authority.
+func (m *CertificateManager) fulfill(challenge *acme.Challenge, domain string) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if challenge.Type == "http-01" {
+		response, err := m.Client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		path := m.Client.HTTP01ChallengePath(challenge.Token)
+		m.tokens[path] = response
+
+		return func() {
+			m.mu.Lock()
+			delete(m.tokens, path)
+			m.mu.Unlock()
+		}, nil
+	}
+
+	cert, err := m.Client.TLSALPN01ChallengeCert(challenge.Token, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	m.challenges[domain] = &cert
+
+	return func() {
+		m.mu.Lock()
+		delete(m.challenges, domain)
+		m.mu.Unlock()
+	}, nil
+}
+
+// register loads or creates the account key, then registers it with the certificate authority.
+func (m *CertificateManager) register(ctx context.Context) error {
+	m.registering.Lock()
+	defer m.registering.Unlock()
+
+	if m.Client.Key != nil {
+		return nil
+	}
+
+	err := os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	key, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+
+	m.Client.Key = key
+
+	account := &acme.Account{}
+	if m.Email != "" {
+		account.Contact = []string{"mailto:" + m.Email}
+	}
+
+	_, err = m.Client.Register(ctx, account, acme.AcceptTOS)
+	if err == acme.ErrAccountAlreadyExists {
+		return nil
+	}
+
+	if err != nil {
+		m.Client.Key = nil
+	}
+
+	return err
+}
+
+func (m *CertificateManager) accountKey() (crypto.Signer, error) {
+	path := m.Dir + "/account.key"
+
+	contents, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(contents)
+		if block == nil {
+			return nil, fmt.Errorf("invalid account key in %s", path)
+		}
+
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encoded}), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
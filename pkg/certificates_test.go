@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestNewCertificateManager_SkipsWildcardHosts(t *testing.T) {
+	service := &Service{
+		Hosts: []string{"~example.com", "*.example.com"},
+	}
+	service.Normalize("example")
+
+	config := &Configuration{
+		Services: ServiceMap{"example": service},
+	}
+	config.Proxy.Normalize()
+
+	m, err := NewCertificateManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{"example.com", "www.example.com"}}
+
+	if !reflect.DeepEqual(m.Groups, expected) {
+		t.Errorf("Expected groups to be %v, got %v", expected, m.Groups)
+	}
+}
+
+func TestCertificateManager_HTTPHandler(t *testing.T) {
+	m, err := NewCertificateManager(&Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.tokens["/.well-known/acme-challenge/token"] = "response"
+
+	handler := m.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	dataset := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/.well-known/acme-challenge/token", http.StatusOK, "response"},
+		{"/.well-known/acme-challenge/unknown", http.StatusNotFound, ""},
+		{"/", http.StatusTeapot, ""},
+	}
+
+	for _, d := range dataset {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com"+d.path, nil))
+
+		if recorder.Code != d.status {
+			t.Errorf("Expected status %d for %s, got %d", d.status, d.path, recorder.Code)
+		}
+
+		if d.body != "" && recorder.Body.String() != d.body {
+			t.Errorf("Expected body %s for %s, got %s", d.body, d.path, recorder.Body.String())
+		}
+	}
+}
+
+func TestCertificateManager_Backoff(t *testing.T) {
+	var requests int32
+
+	directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer directory.Close()
+
+	dir, err := os.MkdirTemp("", "nest-certificates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := NewCertificateManager(&Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Client = &acme.Client{DirectoryURL: directory.URL}
+	m.Dir = dir
+
+	group := []string{"example.com"}
+
+	if _, err = m.certificate(context.Background(), group); err == nil {
+		t.Fatal("Expected the order to fail")
+	}
+
+	ordered := atomic.LoadInt32(&requests)
+
+	if _, err = m.certificate(context.Background(), group); err == nil || atomic.LoadInt32(&requests) != ordered {
+		t.Errorf("Expected the failed group not to be ordered again before its retry time, got %v", err)
+	}
+
+	// an order in progress does not block the other groups
+	lock := m.groupLock(group)
+	lock.Lock()
+	defer lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = m.certificate(context.Background(), []string{"example.org"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the other groups to be ordered while a group is locked")
+	}
+}
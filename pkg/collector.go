@@ -0,0 +1,206 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/redwebcreation/nest/docker"
+	"github.com/redwebcreation/nest/global"
+)
+
+// StaleContainer is a container deployed by nest that no longer receives traffic.
+type StaleContainer struct {
+	ID           string
+	Name         string
+	ServiceName  string
+	DeploymentID string
+	// Service is nil if the service has been removed from the configuration.
+	Service *Service
+	// Definition is the configuration of the service the container was created from, see Service.Definition.
+	Definition string
+}
+
+// StaleContainers lists the containers of deployments older than the one receiving the traffic of their service
+// and the containers of the services removed from the configuration.
+func StaleContainers(config *Configuration) ([]StaleContainer, error) {
+	containers, err := docker.GetNestContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	active := ActiveDeployments(containers, routes)
+
+	var stale []StaleContainer
+
+	for _, c := range containers {
+		name := c.Labels["cloud.usenest.service"]
+		id := c.Labels["cloud.usenest.deployment_id"]
+		service, configured := config.Services[name]
+
+		// newer containers may belong to a deployment in progress
+		if configured && !isNewerDeployment(active[name], id) {
+			continue
+		}
+
+		stale = append(stale, StaleContainer{
+			ID:           c.ID,
+			Name:         strings.TrimPrefix(c.Names[0], "/"),
+			ServiceName:  name,
+			DeploymentID: id,
+			Service:      service,
+			Definition:   c.Labels["cloud.usenest.service_configuration"],
+		})
+	}
+
+	return stale, nil
+}
+
+// Collect runs the preclean hooks inside the container, removes it, then runs the postclean hooks
+// in a new container created from the same image.
+// The hooks of a service removed from the configuration are the ones the container was created with.
+func (s StaleContainer) Collect(emit func(event Event)) error {
+	service, err := s.service()
+	if err != nil {
+		return err
+	}
+
+	c, err := global.Docker.ContainerInspect(context.Background(), s.ID)
+	if err != nil {
+		return err
+	}
+
+	if !c.State.Running && len(service.Hooks.Preclean) > 0 {
+		emit(Notice{Message: "skipped preclean hooks, the container is not running"})
+	}
+
+	for _, hook := range service.Hooks.Preclean {
+		if !c.State.Running {
+			break
+		}
+
+		err = RunHook("preclean", hook, func(ctx context.Context, output io.Writer) (int, error) {
+			return docker.Exec(ctx, s.ID, []string{"sh", "-c", hook.Command}, output)
+		}, emit)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = docker.RemoveContainer(s.ID)
+	if err != nil {
+		return err
+	}
+
+	emit(ContainerRemoved{ID: s.ID, Name: s.Name})
+
+	for _, hook := range service.Hooks.Postclean {
+		err = RunHook("postclean", hook, func(ctx context.Context, output io.Writer) (int, error) {
+			return docker.Run(ctx, &container.Config{
+				Image: c.Config.Image,
+				Env:   c.Config.Env,
+				User:  c.Config.User,
+				Cmd:   []string{"sh", "-c", hook.Command},
+			}, &container.HostConfig{
+				Binds:       c.HostConfig.Binds,
+				Mounts:      c.HostConfig.Mounts,
+				NetworkMode: c.HostConfig.NetworkMode,
+			}, output)
+		}, emit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// service returns the configured service of the container,
+// or the one decoded from its definition if the service has been removed from the configuration.
+func (s StaleContainer) service() (*Service, error) {
+	if s.Service != nil {
+		return s.Service, nil
+	}
+
+	service := &Service{Name: s.ServiceName}
+
+	// the containers created before their definition was labelled have no hooks to run
+	if s.Definition == "" {
+		return service, nil
+	}
+
+	err := json.Unmarshal([]byte(s.Definition), service)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service configuration label: %w", err)
+	}
+
+	service.Name = s.ServiceName
+
+	return service, nil
+}
+
+// CollectContainers collects the stale containers, an error does not prevent the other containers from being collected.
+func CollectContainers(stale []StaleContainer, emit func(c StaleContainer, event Event)) []error {
+	var errors []error
+
+	for _, c := range stale {
+		c := c
+
+		err := c.Collect(func(event Event) {
+			emit(c, event)
+		})
+
+		if err != nil {
+			errors = append(errors, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+
+	return errors
+}
+
+// CollectResult is the outcome of the collection of the stale containers.
+type CollectResult struct {
+	// Stale is the number of stale containers found.
+	Stale int `json:"stale"`
+	// Events of the collection, the service of an event is the one of the container collected.
+	Events []RecordedEvent `json:"events"`
+	// Errors of the containers that could not be collected.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Collect collects the stale containers of the configuration.
+func Collect(config *Configuration) (*CollectResult, error) {
+	stale, err := StaleContainers(config)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CollectResult{Stale: len(stale)}
+
+	errors := CollectContainers(stale, func(c StaleContainer, event Event) {
+		recorded, err := Message{
+			Service: &Service{Name: c.ServiceName},
+			Time:    time.Now(),
+			Event:   event,
+			Seq:     len(result.Events),
+		}.Serialize()
+		if err == nil {
+			result.Events = append(result.Events, recorded)
+		}
+	})
+
+	for _, err = range errors {
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	return result, nil
+}
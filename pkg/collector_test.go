@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaleContainer_RemovedServiceHooks(t *testing.T) {
+	removed := &Service{Name: "worker", Image: "worker:1", Env: EnvMap{"QUEUE": "default"}, Healthcheck: &Healthcheck{HTTP: "/health"}}
+	removed.Normalize("worker")
+	removed.Hooks.Preclean = []Hook{{Command: "drain"}}
+	removed.Hooks.Postclean = []Hook{{Command: "rm -rf /cache/worker"}}
+
+	definition, err := removed.Definition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the service is no longer configured, its hooks come from the label of the container
+	service, err := StaleContainer{ServiceName: "worker", Definition: definition}.service()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if service.Name != "worker" || !reflect.DeepEqual(service.Hooks, removed.Hooks) {
+		t.Errorf("Expected the hooks the container was created with, got %+v", service.Hooks)
+	}
+
+	service, err = StaleContainer{ServiceName: "worker"}.service()
+	if err != nil || len(service.Hooks.Preclean) != 0 || len(service.Hooks.Postclean) != 0 {
+		t.Errorf("Expected a container without definition to have no hooks, got %+v: %v", service, err)
+	}
+
+	if _, err = (StaleContainer{ServiceName: "worker", Definition: "{"}).service(); err == nil {
+		t.Errorf("Expected an invalid definition to fail")
+	}
+}
@@ -5,17 +5,50 @@ import (
 	"fmt"
 	"github.com/redwebcreation/nest/docker"
 	"github.com/redwebcreation/nest/global"
+	"golang.org/x/crypto/acme"
 	"os"
+	"strings"
 )
 
 type Configuration struct {
-	Services   ServiceMap  `yaml:"services"`
-	Registries RegistryMap `yaml:"registries"`
+	Services   ServiceMap         `yaml:"services"`
+	Registries RegistryMap        `yaml:"registries"`
+	Proxy      ProxyConfiguration `yaml:"proxy"`
+}
+
+type ProxyConfiguration struct {
+	// HTTP is the address the proxy listens on for plain HTTP requests.
+	HTTP string `yaml:"http"`
+	// HTTPS is the address the proxy listens on for TLS requests.
+	HTTPS string `yaml:"https"`
+
+	// Acme configures how certificates are obtained for the services' hosts.
+	Acme struct {
+		// Directory is the URL of the ACME directory, it defaults to Let's Encrypt.
+		Directory string `yaml:"directory"`
+		// Email is used by the certificate authority to notify about expiring certificates.
+		Email string `yaml:"email"`
+	} `yaml:"acme"`
+}
+
+func (p *ProxyConfiguration) Normalize() {
+	if p.HTTP == "" {
+		p.HTTP = ":80"
+	}
+
+	if p.HTTPS == "" {
+		p.HTTPS = ":443"
+	}
+
+	if p.Acme.Directory == "" {
+		p.Acme.Directory = acme.LetsEncryptURL
+	}
 }
 
 var (
 	ErrRegistryNotFound = fmt.Errorf("registry not found")
 	ErrInvalidRegistry  = fmt.Errorf("invalid registry")
+	ErrCommitNotFound   = fmt.Errorf("commit not found")
 )
 
 func (c *Configuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -28,6 +61,9 @@ func (c *Configuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	c.Registries = p.Registries
 	c.Services = p.Services
+	c.Proxy = p.Proxy
+
+	c.Proxy.Normalize()
 
 	for _, service := range c.Services {
 		if service.Registry == nil {
@@ -78,3 +114,25 @@ func LoadConfigFromCommit(commit string) error {
 func LoadConfig() error {
 	return LoadConfigFromCommit("")
 }
+
+// ResolveCommit pulls the configuration's branch and returns the commit starting with the given prefix.
+// The latest commit of the branch is returned if the prefix is empty.
+func ResolveCommit(prefix string) (string, error) {
+	err := Config.Git.Pull(Config.Branch)
+	if err != nil {
+		return "", err
+	}
+
+	commits, err := Config.Git.Commits()
+	if err != nil {
+		return "", err
+	}
+
+	for _, commit := range commits {
+		if commit != "" && strings.HasPrefix(commit, prefix) {
+			return commit, nil
+		}
+	}
+
+	return "", ErrCommitNotFound
+}
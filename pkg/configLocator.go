@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/redwebcreation/nest/util"
 )
@@ -22,6 +23,26 @@ var (
 
 var Config = &ConfigLocator{}
 
+// configMu guards Config and the checkout of its repository,
+// the server loads the configuration of each deployment while its handlers read the current one.
+var configMu sync.Mutex
+
+// RetrieveConfig returns the configuration at the current commit, it is safe to call while a deployment loads its own.
+func RetrieveConfig() (*Configuration, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	return Config.Retrieve()
+}
+
+// locatorConfig returns a copy of the settings of the current configuration locator.
+func locatorConfig() ConfigLocatorConfig {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	return Config.ConfigLocatorConfig
+}
+
 type ConfigLocatorConfig struct {
 	Strategy   string
 	Provider   string
@@ -114,11 +135,11 @@ func (l *ConfigLocator) UnmarshalJSON(data []byte) error {
 		}
 
 		l.Commit = string(commit)
+	}
 
-		err = repo.Checkout(l.Commit)
-		if err != nil {
-			return err
-		}
+	err = repo.Checkout(l.Commit)
+	if err != nil {
+		return err
 	}
 
 	l.Git = repo
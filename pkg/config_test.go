@@ -70,6 +70,9 @@ func TestService_ExpandFromConfig(t *testing.T) {
 					"example.com",
 					"www.example.com",
 				},
+				HostGroups: [][]string{
+					{"example.com", "www.example.com"},
+				},
 			},
 		},
 		// test that the default port to forward the load to is 80
@@ -110,5 +113,32 @@ func TestService_ExpandFromConfig(t *testing.T) {
 			t.Errorf("Expected hosts to be %s, got %s", d.output.Hosts, d.input.Hosts)
 		}
 
+		if d.output.HostGroups != nil && reflect.DeepEqual(d.input.HostGroups, d.output.HostGroups) == false {
+			t.Errorf("Expected host groups to be %s, got %s", d.output.HostGroups, d.input.HostGroups)
+		}
+
+	}
+}
+
+func TestConfiguration_ProxyDefaults(t *testing.T) {
+	var config Configuration
+	err := yaml.Unmarshal([]byte(strings.TrimSpace(`
+proxy:
+  acme:
+    directory: https://localhost:14000/dir`)), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Proxy.HTTP != ":80" {
+		t.Errorf("Expected the proxy to listen on :80, got %s", config.Proxy.HTTP)
+	}
+
+	if config.Proxy.HTTPS != ":443" {
+		t.Errorf("Expected the proxy to listen on :443, got %s", config.Proxy.HTTPS)
+	}
+
+	if config.Proxy.Acme.Directory != "https://localhost:14000/dir" {
+		t.Errorf("Expected the acme directory to be https://localhost:14000/dir, got %s", config.Proxy.Acme.Directory)
 	}
 }
@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+var (
+	ErrDaemonRunning = fmt.Errorf("the daemon is already running")
+	ErrStreamClosed  = fmt.Errorf("the daemon closed the event stream before the deployment finished")
+)
+
+// SocketPath returns the path of the unix socket the daemon listens on.
+func SocketPath() string {
+	return global.DataDir + "/nest.sock"
+}
+
+// DaemonRunning returns true if a daemon accepts connections on the socket.
+func DaemonRunning() bool {
+	conn, err := net.DialTimeout("unix", SocketPath(), time.Second)
+	if err != nil {
+		return false
+	}
+
+	_ = conn.Close()
+
+	return true
+}
+
+// ListenSocket listens on the socket of the daemon, only the current user may connect to it.
+// A socket left behind by a daemon that did not exit cleanly is replaced.
+func ListenSocket() (net.Listener, error) {
+	if DaemonRunning() {
+		return nil, ErrDaemonRunning
+	}
+
+	err := os.MkdirAll(global.DataDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Remove(SocketPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", SocketPath())
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(SocketPath(), 0600)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// DaemonClient talks to the API of the daemon through its socket.
+type DaemonClient struct {
+	client *http.Client
+}
+
+func NewDaemonClient() *DaemonClient {
+	return &DaemonClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+
+					return dialer.DialContext(ctx, "unix", SocketPath())
+				},
+			},
+		},
+	}
+}
+
+// Deploy asks the daemon to deploy, the deployment is queued if another one is in progress.
+func (c *DaemonClient) Deploy(request DeployRequest) (*Deployment, error) {
+	var deployment Deployment
+
+	err := c.do(http.MethodPost, "/deployments", request, &deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deployment, nil
+}
+
+// Rollback asks the daemon to roll the services back, the deployments restoring them are queued.
+func (c *DaemonClient) Rollback(request RollbackRequest) (*RollbackResult, error) {
+	var result RollbackResult
+
+	err := c.do(http.MethodPost, "/rollbacks", request, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Collect asks the daemon to collect the stale containers, it fails if a deployment is in progress.
+func (c *DaemonClient) Collect() (*CollectResult, error) {
+	var result CollectResult
+
+	err := c.do(http.MethodPost, "/gc", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Deployment returns the deployment with the given id, in progress, queued or finished.
+func (c *DaemonClient) Deployment(id string) (*Deployment, error) {
+	var deployment Deployment
+
+	err := c.do(http.MethodGet, "/deployments/"+id, nil, &deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deployment, nil
+}
+
+// Services lists the services of the configuration the daemon runs with their containers.
+func (c *DaemonClient) Services() ([]ServiceStatus, error) {
+	var statuses []ServiceStatus
+
+	err := c.do(http.MethodGet, "/services", nil, &statuses)
+
+	return statuses, err
+}
+
+// Follow calls onEvent for every event of the deployment until it finishes and returns the finished deployment.
+// The stream is resumed if the daemon drops it while the deployment is still in progress.
+func (c *DaemonClient) Follow(id string, onEvent func(event RecordedEvent)) (*Deployment, error) {
+	lastEventID := ""
+
+	for {
+		request, err := http.NewRequest(http.MethodGet, "http://nest/deployments/"+id+"/events", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set(actorHeader, LocalActor())
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		response, err := c.client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode != http.StatusOK {
+			err = responseError(response)
+			_ = response.Body.Close()
+
+			return nil, err
+		}
+
+		resumeFrom := lastEventID
+		deployment, err := readEvents(response.Body, func(id string, event RecordedEvent) {
+			lastEventID = id
+			onEvent(event)
+		})
+		_ = response.Body.Close()
+
+		if err != ErrStreamClosed || lastEventID == resumeFrom {
+			return deployment, err
+		}
+	}
+}
+
+// readEvents reads server-sent events until the end event, which carries the finished deployment.
+func readEvents(r io.Reader, onEvent func(id string, event RecordedEvent)) (*Deployment, error) {
+	scanner := bufio.NewScanner(r)
+	// hook output may be long
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var id, name, data string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			if name == "end" {
+				var deployment Deployment
+
+				err := json.Unmarshal([]byte(data), &deployment)
+				if err != nil {
+					return nil, err
+				}
+
+				return &deployment, nil
+			}
+
+			var event RecordedEvent
+
+			err := json.Unmarshal([]byte(data), &event)
+			if err != nil {
+				return nil, err
+			}
+
+			onEvent(id, event)
+
+			id, name, data = "", "", ""
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrStreamClosed
+}
+
+func (c *DaemonClient) do(method string, path string, body interface{}, v interface{}) error {
+	var payload io.Reader
+
+	if body != nil {
+		contents, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		payload = bytes.NewReader(contents)
+	}
+
+	request, err := http.NewRequest(method, "http://nest"+path, payload)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set(actorHeader, LocalActor())
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return responseError(response)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// responseError converts an error response of the API to an error.
+func responseError(response *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error == "" {
+		return fmt.Errorf("the daemon responded with %s", response.Status)
+	}
+
+	return fmt.Errorf("%s", body.Error)
+}
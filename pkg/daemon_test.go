@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadEvents(t *testing.T) {
+	stream := ": heartbeat\n\n" +
+		"id: 0\ndata: {\"service\":\"api\",\"type\":\"notice\",\"message\":\"first\"}\n\n" +
+		"id: 1\ndata: {\"service\":\"api\",\"type\":\"completed\",\"message\":\"deployed\"}\n\n" +
+		"event: end\ndata: {\"id\":\"1000\",\"services\":{\"api\":{\"status\":\"succeeded\"}}}\n\n"
+
+	var ids []string
+
+	deployment, err := readEvents(strings.NewReader(stream), func(id string, event RecordedEvent) {
+		ids = append(ids, id+":"+event.Message)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(ids, ",") != "0:first,1:deployed" {
+		t.Errorf("Expected both events, got %v", ids)
+	}
+
+	if deployment.ID != "1000" || deployment.Services["api"].Status != StatusSucceeded {
+		t.Errorf("Expected the finished deployment, got %+v", deployment)
+	}
+
+	if _, err = readEvents(strings.NewReader("id: 0\ndata: {}\n\n"), func(string, RecordedEvent) {}); err != ErrStreamClosed {
+		t.Errorf("Expected %s, got %v", ErrStreamClosed, err)
+	}
+}
+
+func TestDaemonClient(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	api := &Service{Name: "api"}
+
+	deployment := NewDeployment("commit")
+	deployment.ID = "1000"
+	deployment.Record(Message{Service: api, Event: Notice{Message: "first"}})
+
+	if err := deployment.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if DaemonRunning() {
+		t.Fatal("Expected no daemon to be running")
+	}
+
+	listener, err := ListenSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_ = http.Serve(listener, NewServer("", "").SocketHandler())
+	}()
+	defer listener.Close()
+
+	if !DaemonRunning() {
+		t.Errorf("Expected the daemon to be running")
+	}
+
+	if _, err = ListenSocket(); err != ErrDaemonRunning {
+		t.Errorf("Expected %s, got %v", ErrDaemonRunning, err)
+	}
+
+	client := NewDaemonClient()
+
+	if _, err = client.Deployment("1"); err == nil || err.Error() != ErrDeploymentNotFound.Error() {
+		t.Errorf("Expected %s, got %v", ErrDeploymentNotFound, err)
+	}
+
+	var events []string
+
+	finished, err := client.Follow("1000", func(event RecordedEvent) {
+		events = append(events, event.Message)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if finished.ID != "1000" || len(events) != 1 || events[0] != "first" {
+		t.Errorf("Expected the events of deployment 1000, got %v", events)
+	}
+}
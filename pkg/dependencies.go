@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrDependencyCycle  = fmt.Errorf("dependency cycle")
+	ErrDependencyFailed = fmt.Errorf("dependency failed")
+)
+
+// DependencyCycle returns the services forming a dependency cycle, the first service is repeated at the end.
+// Dependencies outside the map are ignored.
+func (s ServiceMap) DependencyCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(s))
+	var stack []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		stack = append(stack, name)
+
+		for _, dependency := range s[name].DependsOn {
+			if _, ok := s[dependency]; !ok {
+				continue
+			}
+
+			switch state[dependency] {
+			case visiting:
+				for i, n := range stack {
+					if n == dependency {
+						return append(append([]string{}, stack[i:]...), dependency)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+
+		return nil
+	}
+
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if state[name] != unvisited {
+			continue
+		}
+
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// CheckDependencies fails if the services depend on each other in a cycle.
+func (s ServiceMap) CheckDependencies() error {
+	if cycle := s.DependencyCycle(); cycle != nil {
+		return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(cycle, " -> "))
+	}
+
+	return nil
+}
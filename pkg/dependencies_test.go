@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServiceMap_DependencyCycle(t *testing.T) {
+	dataset := []struct {
+		services ServiceMap
+		cycle    []string
+	}{
+		{ServiceMap{
+			"api":      {Name: "api", DependsOn: []string{"database", "cache"}},
+			"database": {Name: "database"},
+			"cache":    {Name: "cache", DependsOn: []string{"database"}},
+		}, nil},
+		{ServiceMap{
+			"api": {Name: "api", DependsOn: []string{"unknown"}},
+		}, nil},
+		{ServiceMap{
+			"api": {Name: "api", DependsOn: []string{"api"}},
+		}, []string{"api", "api"}},
+		{ServiceMap{
+			"api":      {Name: "api", DependsOn: []string{"worker"}},
+			"worker":   {Name: "worker", DependsOn: []string{"database"}},
+			"database": {Name: "database", DependsOn: []string{"api"}},
+		}, []string{"api", "worker", "database", "api"}},
+	}
+
+	for _, d := range dataset {
+		cycle := d.services.DependencyCycle()
+
+		if !reflect.DeepEqual(cycle, d.cycle) {
+			t.Errorf("Expected cycle %v, got %v", d.cycle, cycle)
+		}
+
+		if err := d.services.CheckDependencies(); (err != nil) != (d.cycle != nil) {
+			t.Errorf("Expected CheckDependencies to fail only when there is a cycle, got %v", err)
+		}
+	}
+}
@@ -2,19 +2,36 @@ package pkg
 
 import (
 	"context"
+	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/redwebcreation/nest/docker"
 	"github.com/redwebcreation/nest/global"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+var (
+	ErrContainerNotReady = fmt.Errorf("container stopped before being ready")
+)
+
+// readinessPeriod is how long a new container must stay up before receiving traffic.
+const readinessPeriod = 3 * time.Second
+
 type MessageBus chan Message
 
+// Message is an event of the deployment of a service sent on the bus.
 type Message struct {
 	Service *Service
-	Value   interface{}
+	Time    time.Time
+	Event   Event
+	// Seq is set once the message is recorded by its deployment.
+	Seq int
 }
 
 type DeployPipeline struct {
@@ -29,34 +46,257 @@ func (d DeployPipeline) Run() error {
 		return err
 	}
 
-	id, err := d.CreateContainer()
+	err = d.CreateVolumes()
 	if err != nil {
 		return err
 	}
 
-	err = d.RunHooks(id, d.Service.Hooks.Prestart)
+	err = d.CreateNetworks()
 	if err != nil {
 		return err
 	}
 
-	err = d.StartContainer(id)
+	// the prestart hooks run once per deployment, before any replica starts
+	err = d.RunPrestartHooks()
 	if err != nil {
 		return err
 	}
 
-	err = d.RunHooks(id, d.Service.Hooks.Poststart)
+	routes, err := LoadRoutes()
 	if err != nil {
 		return err
 	}
 
-	d.MessageBus <- Message{
-		Service: d.Service,
-		Value:   io.EOF,
+	previous := routes[d.Service.Name]
+
+	var replicas []string
+
+	// replicas are replaced one by one, the previous containers keep receiving traffic until they are replaced
+	// if a replica fails to start, the traffic stays on the replicas started so far and the remaining previous containers
+	for replica := 0; replica < d.Service.Replicas; replica++ {
+		id, err := d.CreateContainer(replica)
+		if err != nil {
+			return err
+		}
+
+		err = d.Start(id, replica)
+		if err != nil {
+			_ = global.Docker.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{
+				Force: true,
+			})
+
+			return err
+		}
+
+		replicas = append(replicas, id)
+
+		var replaced string
+		if len(previous) > 0 {
+			replaced, previous = previous[0], previous[1:]
+		}
+
+		err = RouteTraffic(d.Service.Name, append(append([]string{}, replicas...), previous...)...)
+		if err != nil {
+			return err
+		}
+
+		d.emit(TrafficSwitched{
+			Replica:  replica,
+			Replicas: d.Service.Replicas,
+		})
+
+		if replaced == "" {
+			continue
+		}
+
+		err = d.retire(func(c StaleContainer) bool {
+			return c.ID == replaced
+		})
+		if err != nil {
+			return err
+		}
 	}
 
+	err = d.RetirePreviousContainers()
+	if err != nil {
+		return err
+	}
+
+	d.emit(Completed{})
+
 	return nil
 }
 
+// Start runs the container and its poststart hooks until it is ready to receive traffic.
+func (d DeployPipeline) Start(id string, replica int) error {
+	err := d.StartContainer(id)
+	if err != nil {
+		return err
+	}
+
+	d.emit(ContainerStarted{ID: id, Replica: replica})
+
+	err = d.WaitUntilReady(id)
+	if err != nil {
+		return err
+	}
+
+	return d.RunPoststartHooks(id)
+}
+
+// Run deploys the services concurrently and records the outcome of each of them in the history.
+// The containers of the services removed from the configuration are collected once every service has been deployed,
+// unless the configuration is nil.
+// The bus is closed once every service has been deployed.
+func (d *Deployment) Run(config *Configuration, services ServiceMap, bus MessageBus) error {
+	defer close(bus)
+
+	err := services.CheckDependencies()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.StartedAt = time.Now()
+
+	for name, service := range services {
+		d.Services[name] = &ServiceDeployment{
+			Image:  service.Image,
+			Status: StatusPending,
+		}
+	}
+	d.mu.Unlock()
+
+	err = d.Save()
+	if err != nil {
+		return err
+	}
+
+	// every message goes through the deployment to be recorded before reaching the bus
+	events := make(MessageBus)
+	forwarded := make(chan struct{})
+
+	go func() {
+		for message := range events {
+			message.Seq = d.Record(message)
+			bus <- message
+		}
+
+		close(forwarded)
+	}()
+
+	var wg sync.WaitGroup
+
+	// closed once the service has been deployed, successfully or not
+	deployed := make(map[string]chan struct{}, len(services))
+	for name := range services {
+		deployed[name] = make(chan struct{})
+	}
+
+	for _, service := range services {
+		wg.Add(1)
+
+		go func(service *Service) {
+			defer wg.Done()
+			defer close(deployed[service.Name])
+
+			var err error
+
+			// dependencies that are not part of this deployment are already running
+			for _, dependency := range service.DependsOn {
+				if _, ok := deployed[dependency]; !ok {
+					continue
+				}
+
+				events <- Message{
+					Service: service,
+					Time:    time.Now(),
+					Event:   WaitingForDependency{Dependency: dependency},
+				}
+
+				<-deployed[dependency]
+
+				d.mu.Lock()
+				if d.Services[dependency].Status != StatusSucceeded {
+					err = fmt.Errorf("%w: %s", ErrDependencyFailed, dependency)
+				}
+				d.mu.Unlock()
+
+				if err != nil {
+					break
+				}
+			}
+
+			if err == nil {
+				err = service.Deploy(d.ID, events)
+			}
+
+			d.mu.Lock()
+			if err != nil {
+				d.Services[service.Name].Status = StatusFailed
+				d.Services[service.Name].Error = err.Error()
+			} else {
+				d.Services[service.Name].Status = StatusSucceeded
+			}
+			d.mu.Unlock()
+
+			if err != nil {
+				events <- Message{
+					Service: service,
+					Time:    time.Now(),
+					Event:   Failed{Error: err.Error()},
+				}
+			}
+		}(service)
+	}
+
+	wg.Wait()
+
+	collectRemovedServices(config, events)
+
+	close(events)
+	<-forwarded
+
+	d.mu.Lock()
+	d.FinishedAt = time.Now()
+	d.mu.Unlock()
+
+	return d.Save()
+}
+
+// collectRemovedServices collects the containers of the services that are no longer in the configuration.
+// A container that can not be collected does not fail the deployment, it is left for `nest gc`.
+func collectRemovedServices(config *Configuration, bus MessageBus) {
+	if config == nil {
+		return
+	}
+
+	stale, err := StaleContainers(config)
+	if err != nil {
+		return
+	}
+
+	for _, c := range stale {
+		if c.Service != nil {
+			continue
+		}
+
+		service := &Service{Name: c.ServiceName}
+		emit := func(event Event) {
+			bus <- Message{
+				Service: service,
+				Time:    time.Now(),
+				Event:   event,
+			}
+		}
+
+		err = c.Collect(emit)
+		if err != nil {
+			emit(Notice{Message: fmt.Sprintf("could not collect %s: %s", c.Name, err)})
+		}
+	}
+}
+
 func (s *Service) Deploy(deploymentID string, bus MessageBus) error {
 	return DeployPipeline{
 		MessageBus:   bus,
@@ -68,58 +308,311 @@ func (s *Service) Deploy(deploymentID string, bus MessageBus) error {
 func (d DeployPipeline) PullImage() error {
 	image := docker.Image(d.Service.Image)
 
+	// the progress of every layer being downloaded, the events only carry the progress of a single layer
+	current := make(map[string]int64)
+	total := make(map[string]int64)
+
 	return image.Pull(func(event *docker.PullEvent) {
-		d.MessageBus <- Message{
-			Service: d.Service,
-			Value:   event.Status,
+		if event.Status == "Downloading" && event.ProgressDetail.Total > 0 {
+			current[event.ID] = int64(event.ProgressDetail.Current)
+			total[event.ID] = int64(event.ProgressDetail.Total)
 		}
-	}, d.Service.Registry.(docker.Registry))
+
+		if event.Status == "Download complete" {
+			current[event.ID] = total[event.ID]
+		}
+
+		progress := PullProgress{Status: event.Status}
+
+		for layer := range total {
+			progress.Current += current[layer]
+			progress.Total += total[layer]
+		}
+
+		d.emit(progress)
+	}, d.Service.registry())
 }
 
-func (d DeployPipeline) CreateContainer() (string, error) {
-	c, err := global.Docker.ContainerCreate(context.Background(), &container.Config{
+// CreateVolumes creates the volumes of the service that do not exist yet.
+func (d DeployPipeline) CreateVolumes() error {
+	for _, volume := range d.Service.Volumes {
+		created, err := docker.EnsureVolume(volume.From, map[string]string{
+			"cloud.usenest.service": d.Service.Name,
+		})
+		if err != nil {
+			return err
+		}
+
+		if created {
+			d.emit(ResourceCreated{Resource: "volume", Name: volume.From})
+		}
+	}
+
+	return nil
+}
+
+// CreateNetworks creates the networks of the service that do not exist yet.
+func (d DeployPipeline) CreateNetworks() error {
+	for _, network := range d.Service.Networks {
+		created, err := docker.EnsureNetwork(NetworkName(network), map[string]string{
+			"cloud.usenest.network": network,
+		})
+		if err != nil {
+			return err
+		}
+
+		if created {
+			d.emit(ResourceCreated{Resource: "network", Name: NetworkName(network)})
+		}
+	}
+
+	return nil
+}
+
+// containerConfig returns the configuration shared by the containers of the service and its prestart hooks.
+func (d DeployPipeline) containerConfig() (*container.Config, *container.HostConfig, error) {
+	mounts := make([]mount.Mount, 0, len(d.Service.Volumes))
+	for _, volume := range d.Service.Volumes {
+		mounts = append(mounts, volume.ToDockerMount())
+	}
+
+	tmpfs := make(map[string]string, len(d.Service.Tmpfs))
+	for _, raw := range d.Service.Tmpfs {
+		target, options, err := ParseTmpfs(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tmpfs[target] = options
+	}
+
+	resources, err := d.Service.Resources.ToDockerResources()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restart, err := ParseRestartPolicy(d.Service.Restart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &container.Config{
 		Image: d.Service.Image,
-		Labels: map[string]string{
-			"cloud.usenest.service":       d.Service.Name,
-			"cloud.usenest.deployment_id": d.DeploymentID,
-		},
-		Env: d.Service.Env.ToDockerEnv(),
+		Env:   d.Service.Env.ToDockerEnv(),
+		User:  d.Service.Security.User,
 	}, &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{
-			Name: "always",
+		RestartPolicy:  restart,
+		Resources:      resources,
+		ReadonlyRootfs: d.Service.Security.ReadOnly,
+		CapAdd:         d.Service.Security.CapAdd,
+		CapDrop:        d.Service.Security.CapDrop,
+		SecurityOpt:    d.Service.Security.SecurityOpt(),
+		Binds:          d.Service.Binds,
+		Mounts:         mounts,
+		Tmpfs:          tmpfs,
+		NetworkMode:    container.NetworkMode(NetworkName(d.Service.Networks[0])),
+	}, nil
+}
+
+// CreateContainer creates the container of the given replica, replicas are numbered from 0.
+func (d DeployPipeline) CreateContainer(replica int) (string, error) {
+	definition, err := d.Service.Definition()
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, err := d.Service.Fingerprint()
+	if err != nil {
+		return "", err
+	}
+
+	config, host, err := d.containerConfig()
+	if err != nil {
+		return "", err
+	}
+
+	config.Labels = map[string]string{
+		"cloud.usenest.service":               d.Service.Name,
+		"cloud.usenest.deployment_id":         d.DeploymentID,
+		"cloud.usenest.service_configuration": definition,
+		"cloud.usenest.fingerprint":           fingerprint,
+		"cloud.usenest.replica":               strconv.Itoa(replica),
+	}
+
+	containerName := "nest_" + d.Service.Name + "_" + strings.Replace(d.Service.Image, ":", "_", 1) + "_" + d.DeploymentID + "_" + strconv.Itoa(replica)
+
+	c, err := global.Docker.ContainerCreate(context.Background(), config, host, &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			NetworkName(d.Service.Networks[0]): d.endpoint(),
 		},
-	}, nil, nil, "nest_"+d.Service.Name+"_"+strings.Replace(d.Service.Image, ":", "_", 1)+"_"+d.DeploymentID)
+	}, nil, containerName)
 
 	if err != nil {
 		return "", err
 	}
 
+	// a container can only be attached to a single network when it is created
+	for _, name := range d.Service.Networks[1:] {
+		err = global.Docker.NetworkConnect(context.Background(), NetworkName(name), c.ID, d.endpoint())
+		if err != nil {
+			_ = global.Docker.ContainerRemove(context.Background(), c.ID, types.ContainerRemoveOptions{})
+			return "", err
+		}
+	}
+
+	d.emit(ContainerCreated{ID: c.ID, Name: containerName, Replica: replica})
+
 	return c.ID, nil
 }
 
-func (d DeployPipeline) RunHooks(id string, commands []string) error {
-	for _, command := range commands {
-		ref, err := global.Docker.ContainerExecCreate(context.Background(), id, types.ExecConfig{
-			Cmd: []string{"sh", "-c", command},
-		})
+// endpoint makes the service reachable through its name on the networks it is attached to.
+func (d DeployPipeline) endpoint() *network.EndpointSettings {
+	return &network.EndpointSettings{
+		Aliases: []string{d.Service.Name},
+	}
+}
+
+// RunPrestartHooks runs the prestart hooks in one-off containers created from the configuration of the service.
+// They are not reachable through the name of the service and do not restart.
+func (d DeployPipeline) RunPrestartHooks() error {
+	for _, hook := range d.Service.Hooks.Prestart {
+		config, host, err := d.containerConfig()
 		if err != nil {
 			return err
 		}
 
-		err = global.Docker.ContainerExecStart(context.Background(), ref.ID, types.ExecStartCheck{})
+		config.Cmd = []string{"sh", "-c", hook.Command}
+		host.RestartPolicy = container.RestartPolicy{}
+
+		err = RunHook("prestart", hook, func(ctx context.Context, output io.Writer) (int, error) {
+			return docker.Run(ctx, config, host, output)
+		}, d.emit)
 		if err != nil {
 			return err
 		}
+	}
 
-		d.MessageBus <- Message{
-			Service: d.Service,
-			Value:   "ran command: " + command,
+	return nil
+}
+
+// RunPoststartHooks runs the poststart hooks inside the container.
+func (d DeployPipeline) RunPoststartHooks(id string) error {
+	for _, hook := range d.Service.Hooks.Poststart {
+		err := RunHook("poststart", hook, func(ctx context.Context, output io.Writer) (int, error) {
+			return docker.Exec(ctx, id, []string{"sh", "-c", hook.Command}, output)
+		}, d.emit)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// emit sends the event on the bus.
+func (d DeployPipeline) emit(event Event) {
+	d.MessageBus <- Message{
+		Service: d.Service,
+		Time:    time.Now(),
+		Event:   event,
+	}
+}
+
 func (d DeployPipeline) StartContainer(id string) error {
 	return global.Docker.ContainerStart(context.Background(), id, types.ContainerStartOptions{})
 }
+
+// WaitUntilReady waits for the container to pass its health check.
+// Services without a health check are ready once their container stayed up for the readiness period.
+func (d DeployPipeline) WaitUntilReady(id string) error {
+	d.emit(HealthStatus{ContainerID: id, Status: HealthStarting})
+
+	check := d.Service.Healthcheck
+
+	if check == nil {
+		deadline := time.Now().Add(readinessPeriod)
+
+		for time.Now().Before(deadline) {
+			if err := d.EnsureRunning(id); err != nil {
+				return err
+			}
+
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		return d.EnsureRunning(id)
+	}
+
+	time.Sleep(check.StartPeriod)
+
+	var output string
+
+	for attempt := 1; attempt <= check.Retries; attempt++ {
+		if err := d.EnsureRunning(id); err != nil {
+			return err
+		}
+
+		var healthy bool
+
+		output, healthy = check.Probe(d.Service, id)
+		if healthy {
+			d.emit(HealthStatus{ContainerID: id, Status: HealthHealthy, Attempt: attempt, Retries: check.Retries, Output: output})
+
+			return nil
+		}
+
+		d.emit(HealthStatus{ContainerID: id, Status: HealthUnhealthy, Attempt: attempt, Retries: check.Retries, Output: output})
+
+		if attempt < check.Retries {
+			time.Sleep(check.Interval)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnhealthy, output)
+}
+
+// EnsureRunning fails if the container stopped or restarted since it was started.
+func (d DeployPipeline) EnsureRunning(id string) error {
+	c, err := global.Docker.ContainerInspect(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	if !c.State.Running || c.State.Restarting || c.RestartCount > 0 {
+		return fmt.Errorf("%w (exit code %d)", ErrContainerNotReady, c.State.ExitCode)
+	}
+
+	return nil
+}
+
+// RetirePreviousContainers collects the containers of the previous deployments of the service.
+// A container that can not be collected does not fail the deployment, it is left for `nest gc`.
+func (d DeployPipeline) RetirePreviousContainers() error {
+	return d.retire(func(c StaleContainer) bool {
+		return true
+	})
+}
+
+// retire collects the stale containers of the service matching the filter.
+func (d DeployPipeline) retire(filter func(c StaleContainer) bool) error {
+	// the other services are seen as removed from this configuration, their containers are ignored below
+	stale, err := StaleContainers(&Configuration{
+		Services: ServiceMap{d.Service.Name: d.Service},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range stale {
+		if c.ServiceName != d.Service.Name || !filter(c) {
+			continue
+		}
+
+		err = c.Collect(d.emit)
+		if err != nil {
+			d.emit(Notice{Message: fmt.Sprintf("could not collect %s: %s", c.Name, err)})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// Event is something that happened while deploying a service.
+// Events are serialized to JSON along with their type, see Message.MarshalJSON.
+type Event interface {
+	// Type identifies the event once serialized, such as pull_progress.
+	Type() string
+	// String describes the event in a human readable way.
+	String() string
+}
+
+// PullProgress reports the progress of the image pull, in bytes downloaded across every layer.
+type PullProgress struct {
+	Status  string `json:"status"`
+	Current int64  `json:"current"`
+	Total   int64  `json:"total"`
+}
+
+func (e PullProgress) Type() string { return "pull_progress" }
+
+func (e PullProgress) String() string {
+	if e.Total == 0 {
+		return e.Status
+	}
+
+	return fmt.Sprintf("pulling image: %s/%s", units.HumanSize(float64(e.Current)), units.HumanSize(float64(e.Total)))
+}
+
+// ResourceCreated is sent when a volume or a network used by the service is created.
+type ResourceCreated struct {
+	// Resource is either volume or network.
+	Resource string `json:"resource"`
+	Name     string `json:"name"`
+}
+
+func (e ResourceCreated) Type() string { return "resource_created" }
+
+func (e ResourceCreated) String() string {
+	return fmt.Sprintf("created %s %s", e.Resource, e.Name)
+}
+
+// WaitingForDependency is sent when the deployment of the service waits for a service it depends on.
+type WaitingForDependency struct {
+	Dependency string `json:"dependency"`
+}
+
+func (e WaitingForDependency) Type() string { return "waiting_for_dependency" }
+
+func (e WaitingForDependency) String() string {
+	return "waiting for " + e.Dependency
+}
+
+type HookStarted struct {
+	Stage   string `json:"stage"`
+	Command string `json:"command"`
+}
+
+func (e HookStarted) Type() string { return "hook_started" }
+
+func (e HookStarted) String() string {
+	return fmt.Sprintf("running %s hook: %s", e.Stage, e.Command)
+}
+
+// HookOutput is a line written by a hook to its stdout or stderr.
+type HookOutput struct {
+	Stage   string `json:"stage"`
+	Command string `json:"command"`
+	Line    string `json:"line"`
+}
+
+func (e HookOutput) Type() string { return "hook_output" }
+
+func (e HookOutput) String() string {
+	return e.Line
+}
+
+type HookFinished struct {
+	Stage    string        `json:"stage"`
+	Command  string        `json:"command"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (e HookFinished) Type() string { return "hook_finished" }
+
+func (e HookFinished) String() string {
+	return fmt.Sprintf("%s hook exited with code %d after %s: %s", e.Stage, e.ExitCode, e.Duration.Round(time.Millisecond), e.Command)
+}
+
+type ContainerCreated struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Replica int    `json:"replica"`
+}
+
+func (e ContainerCreated) Type() string { return "container_created" }
+
+func (e ContainerCreated) String() string {
+	return "created container " + e.Name
+}
+
+type ContainerStarted struct {
+	ID      string `json:"id"`
+	Replica int    `json:"replica"`
+}
+
+func (e ContainerStarted) Type() string { return "container_started" }
+
+func (e ContainerStarted) String() string {
+	return fmt.Sprintf("started replica %d", e.Replica+1)
+}
+
+// ContainerRemoved is sent when a container of a previous deployment is collected.
+type ContainerRemoved struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (e ContainerRemoved) Type() string { return "container_removed" }
+
+func (e ContainerRemoved) String() string {
+	return "removed container " + e.Name
+}
+
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// HealthStatus reports the readiness of a new container, Attempt and Retries are only set by health checks.
+type HealthStatus struct {
+	ContainerID string `json:"container_id"`
+	Status      string `json:"status"`
+	Attempt     int    `json:"attempt,omitempty"`
+	Retries     int    `json:"retries,omitempty"`
+	Output      string `json:"output,omitempty"`
+}
+
+func (e HealthStatus) Type() string { return "health_status" }
+
+func (e HealthStatus) String() string {
+	switch e.Status {
+	case HealthStarting:
+		return "waiting for the container to be ready"
+	case HealthUnhealthy:
+		return fmt.Sprintf("health check %d/%d failed: %s", e.Attempt, e.Retries, e.Output)
+	}
+
+	return "container is healthy"
+}
+
+// TrafficSwitched is sent once a new replica receives traffic.
+type TrafficSwitched struct {
+	Replica  int `json:"replica"`
+	Replicas int `json:"replicas"`
+}
+
+func (e TrafficSwitched) Type() string { return "traffic_switched" }
+
+func (e TrafficSwitched) String() string {
+	return fmt.Sprintf("switched traffic to replica %d/%d", e.Replica+1, e.Replicas)
+}
+
+// Notice is an informative event that does not change the state of the deployment.
+type Notice struct {
+	Message string `json:"message"`
+}
+
+func (e Notice) Type() string { return "notice" }
+
+func (e Notice) String() string {
+	return e.Message
+}
+
+// Completed is the last event of a service deployed successfully.
+type Completed struct{}
+
+func (e Completed) Type() string { return "completed" }
+
+func (e Completed) String() string {
+	return "deployed"
+}
+
+// Failed is the last event of a service that could not be deployed.
+type Failed struct {
+	Error string `json:"error"`
+}
+
+func (e Failed) Type() string { return "failed" }
+
+func (e Failed) String() string {
+	return e.Error
+}
+
+// RecordedEvent is the serialized form of a message, as kept in the history.
+type RecordedEvent struct {
+	// Seq numbers the event within its deployment, it identifies the event whether it is streamed live or replayed.
+	Seq     int             `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Service string          `json:"service"`
+	Type    string          `json:"type"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Serialize converts the message to its serialized form.
+func (m Message) Serialize() (RecordedEvent, error) {
+	data, err := json.Marshal(m.Event)
+	if err != nil {
+		return RecordedEvent{}, err
+	}
+
+	event := RecordedEvent{
+		Seq:     m.Seq,
+		Time:    m.Time,
+		Service: m.Service.Name,
+		Type:    m.Event.Type(),
+		Message: m.Event.String(),
+		Data:    data,
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	return event, nil
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	event, err := m.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(event)
+}
+
+// eventTypes are the events a recorded event may be decoded to.
+var eventTypes = []Event{
+	PullProgress{}, ResourceCreated{}, WaitingForDependency{},
+	HookStarted{}, HookOutput{}, HookFinished{},
+	ContainerCreated{}, ContainerStarted{}, ContainerRemoved{},
+	HealthStatus{}, TrafficSwitched{}, Notice{}, Completed{}, Failed{},
+}
+
+// Decode converts the recorded event back to a message, the service of the message only has a name.
+// Events of an unknown type are decoded to a notice.
+func (e RecordedEvent) Decode() (Message, error) {
+	message := Message{
+		Service: &Service{Name: e.Service},
+		Time:    e.Time,
+		Event:   Notice{Message: e.Message},
+	}
+
+	for _, eventType := range eventTypes {
+		if eventType.Type() != e.Type {
+			continue
+		}
+
+		event := reflect.New(reflect.TypeOf(eventType))
+
+		if len(e.Data) > 0 {
+			err := json.Unmarshal(e.Data, event.Interface())
+			if err != nil {
+				return Message{}, err
+			}
+		}
+
+		message.Event = event.Elem().Interface().(Event)
+		break
+	}
+
+	return message, nil
+}
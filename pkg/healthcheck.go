@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redwebcreation/nest/docker"
+)
+
+var (
+	ErrUnhealthy = fmt.Errorf("container never became healthy")
+)
+
+type Healthcheck struct {
+	// HTTP is the path requested on the port the service listens on.
+	HTTP string `yaml:"http"`
+	// Status is the status code expected from the HTTP check.
+	Status int `yaml:"status"`
+	// TCP checks that the service accepts connections on the port it listens on.
+	TCP bool `yaml:"tcp"`
+	// Command is run inside the container, the check passes if it exits with 0.
+	Command string `yaml:"command"`
+
+	// Interval between two checks.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout of a single check.
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is how many checks may fail before the container is considered unhealthy.
+	Retries int `yaml:"retries"`
+	// StartPeriod is how long to wait after the container started before running the first check.
+	StartPeriod time.Duration `yaml:"start_period"`
+}
+
+func (h *Healthcheck) Normalize() {
+	if h.HTTP != "" && h.Status == 0 {
+		h.Status = http.StatusOK
+	}
+
+	if h.Interval == 0 {
+		h.Interval = 2 * time.Second
+	}
+
+	if h.Timeout == 0 {
+		h.Timeout = time.Second
+	}
+
+	if h.Retries == 0 {
+		h.Retries = 10
+	}
+}
+
+// Probe runs the check once against the container, it returns the output of the check and whether it passed.
+func (h *Healthcheck) Probe(service *Service, id string) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+
+	if h.Command != "" {
+		var output bytes.Buffer
+
+		code, err := docker.Exec(ctx, id, []string{"sh", "-c", h.Command}, &output)
+		if err != nil {
+			return err.Error(), false
+		}
+
+		out := strings.TrimSpace(output.String())
+		if code != 0 {
+			return fmt.Sprintf("exited with code %d: %s", code, out), false
+		}
+
+		return out, true
+	}
+
+	ip, err := docker.InspectIPAddress(id)
+	if err != nil {
+		return err.Error(), false
+	}
+
+	address := net.JoinHostPort(ip, service.ListeningOn)
+
+	if h.HTTP != "" {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+h.HTTP, nil)
+		if err != nil {
+			return err.Error(), false
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return err.Error(), false
+		}
+		_ = response.Body.Close()
+
+		output := fmt.Sprintf("GET %s returned %d", h.HTTP, response.StatusCode)
+
+		return output, response.StatusCode == h.Status
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err.Error(), false
+	}
+	_ = conn.Close()
+
+	return "accepted a connection on " + address, true
+}
@@ -0,0 +1,272 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+var (
+	ErrDeploymentNotFound = fmt.Errorf("deployment not found")
+)
+
+var (
+	// lastID is the id of the latest deployment created by this process.
+	lastID   int64
+	lastIDMu sync.Mutex
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Deployment is the record of a deployment kept in the history.
+type Deployment struct {
+	// ID is the time the deployment was created at, in milliseconds, bumped past the ids issued before.
+	ID string `json:"id"`
+	// Commit of the configuration that was deployed.
+	Commit     string                        `json:"commit"`
+	StartedAt  time.Time                     `json:"started_at"`
+	FinishedAt time.Time                     `json:"finished_at"`
+	Services   map[string]*ServiceDeployment `json:"services"`
+	// Skipped lists the services that were up to date.
+	Skipped []string `json:"skipped,omitempty"`
+	// Error is why the deployment could not run, the services failing individually are not reported here.
+	Error  string          `json:"error,omitempty"`
+	Events []RecordedEvent `json:"events"`
+
+	// recorded is the number of messages recorded, collapsed ones included.
+	recorded int
+	// mu guards the services and the events while the deployment runs.
+	mu sync.Mutex
+}
+
+// ServiceDeployment is the outcome of the deployment of a single service.
+type ServiceDeployment struct {
+	Image  string `json:"image"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// RolledBack is true once a rollback replaced this deployment of the service.
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+func NewDeployment(commit string) *Deployment {
+	return &Deployment{
+		ID:       nextDeploymentID(),
+		Commit:   commit,
+		Services: make(map[string]*ServiceDeployment),
+	}
+}
+
+// nextDeploymentID returns the current time in milliseconds, or the next free millisecond if a deployment
+// created by this process or saved in the history already has this id, so that the deployments created at once differ.
+func nextDeploymentID() string {
+	lastIDMu.Lock()
+	defer lastIDMu.Unlock()
+
+	id := time.Now().UnixMilli()
+	if id <= lastID {
+		id = lastID + 1
+	}
+
+	for {
+		if _, err := os.Stat(historyDir() + "/" + strconv.FormatInt(id, 10) + ".json"); os.IsNotExist(err) {
+			break
+		}
+
+		id++
+	}
+
+	lastID = id
+
+	return strconv.FormatInt(id, 10)
+}
+
+// Record appends the message to the events of the deployment and returns its sequence number.
+// Consecutive pull progress of a service only keeps the latest, every other event is recorded as is.
+func (d *Deployment) Record(message Message) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	message.Seq = d.recorded
+	d.recorded++
+
+	event, err := message.Serialize()
+	if err != nil {
+		event = RecordedEvent{
+			Seq:     message.Seq,
+			Time:    time.Now(),
+			Service: message.Service.Name,
+			Type:    message.Event.Type(),
+			Message: message.Event.String(),
+		}
+	}
+
+	for i := len(d.Events) - 1; i >= 0; i-- {
+		if d.Events[i].Service != event.Service {
+			continue
+		}
+
+		// the latest progress moves to the end so that the events stay ordered by sequence number
+		if d.Events[i].Type == event.Type && event.Type == (PullProgress{}).Type() {
+			d.Events = append(d.Events[:i], d.Events[i+1:]...)
+		}
+
+		break
+	}
+
+	d.Events = append(d.Events, event)
+
+	return event.Seq
+}
+
+// fail records why the deployment could not run.
+func (d *Deployment) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Error = err.Error()
+
+	if d.FinishedAt.IsZero() {
+		d.FinishedAt = time.Now()
+	}
+}
+
+// Snapshot returns a copy of the deployment that is safe to read while the deployment runs.
+func (d *Deployment) Snapshot() *Deployment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	snapshot := &Deployment{
+		ID:         d.ID,
+		Commit:     d.Commit,
+		StartedAt:  d.StartedAt,
+		FinishedAt: d.FinishedAt,
+		Services:   make(map[string]*ServiceDeployment, len(d.Services)),
+		Skipped:    d.Skipped,
+		Error:      d.Error,
+		Events:     append([]RecordedEvent{}, d.Events...),
+	}
+
+	for name, service := range d.Services {
+		s := *service
+		snapshot.Services[name] = &s
+	}
+
+	return snapshot
+}
+
+func historyDir() string {
+	return global.DataDir + "/deployments"
+}
+
+// Save writes the deployment to the history.
+func (d *Deployment) Save() error {
+	d.mu.Lock()
+	contents, err := json.MarshalIndent(d, "", "  ")
+	d.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(historyDir(), 0700)
+	if err != nil {
+		return err
+	}
+
+	path := historyDir() + "/" + d.ID + ".json"
+
+	err = os.WriteFile(path+".tmp", contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func LoadDeployment(id string) (*Deployment, error) {
+	contents, err := os.ReadFile(historyDir() + "/" + id + ".json")
+	if os.IsNotExist(err) {
+		return nil, ErrDeploymentNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var deployment Deployment
+
+	err = json.Unmarshal(contents, &deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deployment, nil
+}
+
+// History lists the deployments from the most recent to the oldest.
+type History []*Deployment
+
+func LoadHistory() (History, error) {
+	entries, err := os.ReadDir(historyDir())
+	if os.IsNotExist(err) {
+		return History{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var history History
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		deployment, err := LoadDeployment(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, deployment)
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return isNewerDeployment(history[i].ID, history[j].ID)
+	})
+
+	return history, nil
+}
+
+// RollbackTarget returns the deployment currently serving the service and the one a rollback would restore,
+// the most recent successful deployment from another commit that has not been rolled back.
+func (h History) RollbackTarget(service string) (current *Deployment, target *Deployment) {
+	for _, deployment := range h {
+		s, ok := deployment.Services[service]
+		if !ok || s.Status != StatusSucceeded || s.RolledBack {
+			continue
+		}
+
+		if current == nil {
+			current = deployment
+			continue
+		}
+
+		if deployment.Commit != current.Commit {
+			return current, deployment
+		}
+	}
+
+	return current, nil
+}
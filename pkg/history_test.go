@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+func TestHistory_RollbackTarget(t *testing.T) {
+	deployment := func(id string, commit string, status string, rolledBack bool) *Deployment {
+		return &Deployment{
+			ID:     id,
+			Commit: commit,
+			Services: map[string]*ServiceDeployment{
+				"api": {Status: status, RolledBack: rolledBack},
+			},
+		}
+	}
+
+	dataset := []struct {
+		history History
+		current string
+		target  string
+	}{
+		{History{}, "", ""},
+		{History{deployment("2", "b", StatusSucceeded, false), deployment("1", "a", StatusSucceeded, false)}, "2", "1"},
+		// failed deployments are never restored
+		{History{deployment("3", "c", StatusFailed, false), deployment("2", "b", StatusSucceeded, false), deployment("1", "a", StatusFailed, false)}, "2", ""},
+		// redeploying the same commit is not a rollback target
+		{History{deployment("3", "b", StatusSucceeded, false), deployment("2", "b", StatusSucceeded, false), deployment("1", "a", StatusSucceeded, false)}, "3", "1"},
+		// successive rollbacks walk back the history
+		{History{deployment("4", "b", StatusSucceeded, false), deployment("3", "c", StatusSucceeded, true), deployment("2", "b", StatusSucceeded, false), deployment("1", "a", StatusSucceeded, false)}, "4", "1"},
+	}
+
+	for i, d := range dataset {
+		current, target := d.history.RollbackTarget("api")
+
+		if (current == nil && d.current != "") || (current != nil && current.ID != d.current) {
+			t.Errorf("#%d: expected current deployment to be %s, got %v", i, d.current, current)
+		}
+
+		if (target == nil && d.target != "") || (target != nil && target.ID != d.target) {
+			t.Errorf("#%d: expected target deployment to be %s, got %v", i, d.target, target)
+		}
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	originalDataDir := global.DataDir
+	global.DataDir = dir
+	defer func() { global.DataDir = originalDataDir }()
+
+	for _, id := range []string{"999", "1000", "998"} {
+		deployment := NewDeployment("commit")
+		deployment.ID = id
+
+		if err = deployment.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 3 || history[0].ID != "1000" || history[2].ID != "998" {
+		t.Errorf("Expected the history to be sorted from the most recent deployment")
+	}
+
+	if _, err = LoadDeployment("1"); err != ErrDeploymentNotFound {
+		t.Errorf("Expected %s, got %v", ErrDeploymentNotFound, err)
+	}
+}
+
+func TestNewDeployment_UniqueIDs(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	// the deployments of the next milliseconds were saved by another process
+	now := time.Now().UnixMilli()
+	saved := make(map[string]bool)
+
+	for i := int64(0); i < 50; i++ {
+		id := strconv.FormatInt(now+i, 10)
+		if err := (&Deployment{ID: id}).Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		saved[id] = true
+	}
+
+	issued := make(map[string]bool)
+
+	for i := 0; i < 10; i++ {
+		id := NewDeployment("").ID
+
+		if saved[id] || issued[id] {
+			t.Fatalf("Expected a new id, got %s twice", id)
+		}
+
+		issued[id] = true
+	}
+}
+
+func TestDeployment_Record(t *testing.T) {
+	deployment := NewDeployment("commit")
+	api := &Service{Name: "api"}
+	web := &Service{Name: "web"}
+
+	deployment.Record(Message{Service: api, Event: Notice{Message: "waiting"}})
+	deployment.Record(Message{Service: web, Event: Notice{Message: "waiting"}})
+	deployment.Record(Message{Service: api, Event: Notice{Message: "waiting"}})
+	deployment.Record(Message{Service: api, Event: PullProgress{Status: "Downloading", Current: 1, Total: 10}})
+	deployment.Record(Message{Service: api, Event: PullProgress{Status: "Downloading", Current: 10, Total: 10}})
+	deployment.Record(Message{Service: api, Event: HookOutput{Stage: "prestart", Line: "retrying"}})
+	deployment.Record(Message{Service: api, Event: HookOutput{Stage: "prestart", Line: "retrying"}})
+	deployment.Record(Message{Service: api, Event: HookFinished{Stage: "prestart", Command: "migrate", ExitCode: 1}})
+	deployment.Record(Message{Service: api, Event: Completed{}})
+
+	expected := []string{"api: waiting", "web: waiting", "api: waiting", "api: pulling image: 10B/10B", "api: retrying", "api: retrying", "api: prestart hook exited with code 1 after 0s: migrate", "api: deployed"}
+
+	if len(deployment.Events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), len(deployment.Events))
+	}
+
+	for i, event := range deployment.Events {
+		if event.Service+": "+event.Message != expected[i] {
+			t.Errorf("Expected event %s, got %s: %s", expected[i], event.Service, event.Message)
+		}
+	}
+}
+
+func TestMessage_MarshalJSON(t *testing.T) {
+	message := Message{
+		Service: &Service{Name: "api"},
+		Event:   HookFinished{Stage: "prestart", Command: "migrate", ExitCode: 1},
+	}
+
+	contents, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event struct {
+		Service string `json:"service"`
+		Type    string `json:"type"`
+		Data    struct {
+			ExitCode int `json:"exit_code"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(contents, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Service != "api" || event.Type != "hook_finished" || event.Data.ExitCode != 1 {
+		t.Errorf("Unexpected serialized event %s", contents)
+	}
+}
+
+func TestRecordedEvent_Decode(t *testing.T) {
+	original := Message{
+		Service: &Service{Name: "api"},
+		Event:   HookFinished{Stage: "prestart", Command: "migrate", ExitCode: 1},
+	}
+
+	event, err := original.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := event.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.Service.Name != "api" || message.Event != original.Event {
+		t.Errorf("Expected %v, got %v", original.Event, message.Event)
+	}
+
+	message, _ = RecordedEvent{Service: "api", Type: "unknown", Message: "something"}.Decode()
+	if message.Event != (Notice{Message: "something"}) {
+		t.Errorf("Expected unknown events to be decoded to a notice, got %v", message.Event)
+	}
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrHookFailed   = fmt.Errorf("hook failed")
+	ErrHookTimedOut = fmt.Errorf("hook timed out")
+)
+
+// defaultHookTimeout is how long a hook may run unless it specifies its own timeout.
+const defaultHookTimeout = 10 * time.Minute
+
+// Hook is a command run during the lifecycle of a service.
+// It is either a command or a map with a command and a timeout.
+type Hook struct {
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h *Hook) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		h.Command = node.Value
+		return nil
+	}
+
+	type plain Hook
+
+	return node.Decode((*plain)(h))
+}
+
+// RunHook runs the hook until it exits or times out, every line it outputs is emitted as soon as it is written.
+// The hook fails if it exits with a non-zero code.
+func RunHook(stage string, hook Hook, run func(ctx context.Context, output io.Writer) (int, error), emit func(event Event)) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	emit(HookStarted{Stage: stage, Command: hook.Command})
+
+	started := time.Now()
+
+	output := &lineWriter{report: func(line string) {
+		emit(HookOutput{Stage: stage, Command: hook.Command, Line: line})
+	}}
+	code, err := run(ctx, output)
+	output.Close()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %s did not exit within %s", ErrHookTimedOut, hook.Command, timeout)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	emit(HookFinished{Stage: stage, Command: hook.Command, ExitCode: code, Duration: time.Since(started)})
+
+	if code != 0 {
+		return fmt.Errorf("%w: %s exited with code %d", ErrHookFailed, hook.Command, code)
+	}
+
+	return nil
+}
+
+// lineWriter reports every line written to it, the lines written after it is closed are discarded.
+type lineWriter struct {
+	report func(line string)
+
+	mu     sync.Mutex
+	buffer bytes.Buffer
+	closed bool
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return len(p), nil
+	}
+
+	w.buffer.Write(p)
+
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line until the rest of it is written
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+
+			return len(p), nil
+		}
+
+		w.report(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// Close reports the last line if it did not end with a newline.
+func (w *lineWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buffer.Len() > 0 {
+		w.report(w.buffer.String())
+		w.buffer.Reset()
+	}
+
+	w.closed = true
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestHook_UnmarshalYAML(t *testing.T) {
+	var hooks []Hook
+
+	err := yaml.Unmarshal([]byte(`
+- php artisan migrate
+- command: php artisan cache:clear
+  timeout: 30s
+`), &hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Hook{
+		{Command: "php artisan migrate"},
+		{Command: "php artisan cache:clear", Timeout: 30 * time.Second},
+	}
+
+	if !reflect.DeepEqual(hooks, expected) {
+		t.Errorf("Expected %v, got %v", expected, hooks)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	var events []Event
+	emit := func(event Event) {
+		events = append(events, event)
+	}
+
+	err := RunHook("prestart", Hook{Command: "migrate"}, func(ctx context.Context, output io.Writer) (int, error) {
+		_, _ = output.Write([]byte("migrating\nmigr"))
+		_, _ = output.Write([]byte("ated\ndone"))
+
+		return 0, nil
+	}, emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 events, got %v", events)
+	}
+
+	expected := []Event{
+		HookStarted{Stage: "prestart", Command: "migrate"},
+		HookOutput{Stage: "prestart", Command: "migrate", Line: "migrating"},
+		HookOutput{Stage: "prestart", Command: "migrate", Line: "migrated"},
+		HookOutput{Stage: "prestart", Command: "migrate", Line: "done"},
+	}
+	if !reflect.DeepEqual(events[:4], expected) {
+		t.Errorf("Expected events %v, got %v", expected, events[:4])
+	}
+
+	if finished, ok := events[4].(HookFinished); !ok || finished.ExitCode != 0 {
+		t.Errorf("Expected the hook to finish with code 0, got %v", events[4])
+	}
+
+	err = RunHook("prestart", Hook{Command: "migrate"}, func(ctx context.Context, output io.Writer) (int, error) {
+		return 1, nil
+	}, emit)
+	if !errors.Is(err, ErrHookFailed) {
+		t.Errorf("Expected the hook to fail, got %v", err)
+	}
+
+	err = RunHook("prestart", Hook{Command: "sleep", Timeout: time.Millisecond}, func(ctx context.Context, output io.Writer) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}, emit)
+	if !errors.Is(err, ErrHookTimedOut) {
+		t.Errorf("Expected the hook to time out, got %v", err)
+	}
+}
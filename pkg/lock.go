@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package pkg
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile blocks until the process holds an exclusive lock on the file.
+// The lock is released when the file is closed, even if the process dies.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
@@ -0,0 +1,8 @@
+package pkg
+
+import "os"
+
+// lockFile is a no-op on windows, the updates are only serialized within the process.
+func lockFile(file *os.File) error {
+	return nil
+}
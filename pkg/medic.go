@@ -2,7 +2,11 @@ package pkg
 
 import (
 	"fmt"
+	"net/url"
+	"os"
 	"regexp"
+	"runtime"
+	"strings"
 )
 
 type Diagnosis struct {
@@ -38,12 +42,28 @@ func DiagnoseConfiguration() *Diagnosis {
 	}
 
 	diagnosis.ValidateServicesConfiguration()
+	diagnosis.ValidateProxyConfiguration()
 
 	return &diagnosis
 }
 
 func (d *Diagnosis) ValidateServicesConfiguration() {
+	if err := d.Config.Services.CheckDependencies(); err != nil {
+		d.Errors = append(d.Errors, Error{
+			Title: "Services depend on each other in a cycle",
+			Error: err,
+		})
+	}
+
 	for _, service := range d.Config.Services {
+		for _, dependency := range service.DependsOn {
+			if _, ok := d.Config.Services[dependency]; !ok {
+				d.Errors = append(d.Errors, Error{
+					Title: fmt.Sprintf("Service %s depends on %s which does not exist", service.Name, dependency),
+				})
+			}
+		}
+
 		if service.Image == "" {
 			d.Errors = append(d.Errors, Error{
 				Title: fmt.Sprintf("Service %s has no image", service.Name),
@@ -89,5 +109,225 @@ func (d *Diagnosis) ValidateServicesConfiguration() {
 				})
 			}
 		}
+
+		if service.Healthcheck != nil {
+			d.ValidateHealthcheck(service)
+		}
+
+		d.ValidateMounts(service)
+		d.ValidateResources(service)
+		d.ValidateHooks(service)
+
+		if service.Replicas < 1 {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s must have at least one replica", service.Name),
+			})
+		}
+
+		if service.LoadBalancing != RoundRobin && service.LoadBalancing != LeastConnections {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s has an invalid load balancing strategy %s", service.Name, service.LoadBalancing),
+				Error: fmt.Errorf("load_balancing must be %s or %s", RoundRobin, LeastConnections),
+			})
+		}
+
+		for _, network := range service.Networks {
+			if !networkNameRegex.MatchString(network) {
+				d.Errors = append(d.Errors, Error{
+					Title: fmt.Sprintf("Service %s has an invalid network name %s", service.Name, network),
+				})
+			}
+		}
+	}
+}
+
+var networkNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func (d *Diagnosis) ValidateMounts(service *Service) {
+	targets := make(map[string]bool)
+
+	checkTarget := func(target string) {
+		if targets[target] {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s mounts %s more than once", service.Name, target),
+			})
+		}
+
+		targets[target] = true
+	}
+
+	for _, volume := range service.Volumes {
+		if err := volume.Validate(); err != nil {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s has an invalid volume", service.Name),
+				Error: err,
+			})
+		}
+
+		checkTarget(volume.To)
+	}
+
+	for _, raw := range service.Tmpfs {
+		target, _, err := ParseTmpfs(raw)
+		if err != nil {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s has an invalid tmpfs %s", service.Name, raw),
+				Error: err,
+			})
+			continue
+		}
+
+		checkTarget(target)
+	}
+
+	for _, raw := range service.Binds {
+		bind, err := ParseBind(raw)
+		if err == nil {
+			err = bind.Validate()
+		}
+
+		if err != nil {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s has an invalid bind %s", service.Name, raw),
+				Error: err,
+			})
+			continue
+		}
+
+		checkTarget(bind.Target)
+
+		if _, err = os.Stat(bind.Source); err != nil {
+			d.Warnings = append(d.Warnings, Warning{
+				Title:  fmt.Sprintf("Service %s binds %s which does not exist", service.Name, bind.Source),
+				Advice: "Docker creates missing directories owned by root, create it beforehand with the right permissions.",
+			})
+		}
+	}
+}
+
+func (d *Diagnosis) ValidateHooks(service *Service) {
+	stages := map[string][]Hook{
+		"prestart":  service.Hooks.Prestart,
+		"poststart": service.Hooks.Poststart,
+		"preclean":  service.Hooks.Preclean,
+		"postclean": service.Hooks.Postclean,
+	}
+
+	for _, stage := range []string{"prestart", "poststart", "preclean", "postclean"} {
+		for _, hook := range stages[stage] {
+			if strings.TrimSpace(hook.Command) == "" {
+				d.Errors = append(d.Errors, Error{
+					Title: fmt.Sprintf("Service %s has an empty %s hook", service.Name, stage),
+				})
+			}
+
+			if hook.Timeout < 0 {
+				d.Errors = append(d.Errors, Error{
+					Title: fmt.Sprintf("Service %s has a %s hook with a negative timeout", service.Name, stage),
+				})
+			}
+		}
+	}
+}
+
+// minimumMemory is the lowest memory limit accepted by docker.
+const minimumMemory = 6 * 1024 * 1024
+
+func (d *Diagnosis) ValidateResources(service *Service) {
+	if err := service.Resources.Validate(); err != nil {
+		d.Errors = append(d.Errors, Error{
+			Title: fmt.Sprintf("Service %s has invalid resource limits", service.Name),
+			Error: err,
+		})
+	} else if resources, _ := service.Resources.ToDockerResources(); resources.Memory > 0 && resources.Memory < minimumMemory {
+		d.Errors = append(d.Errors, Error{
+			Title: fmt.Sprintf("Service %s has a memory limit below 6MB", service.Name),
+		})
+	}
+
+	if service.Resources.CPUs > float64(runtime.NumCPU()) {
+		d.Warnings = append(d.Warnings, Warning{
+			Title:  fmt.Sprintf("Service %s may use %g CPUs but the host only has %d", service.Name, service.Resources.CPUs, runtime.NumCPU()),
+			Advice: "The limit has no effect, lower it or remove it.",
+		})
+	}
+
+	if err := service.Security.Validate(); err != nil {
+		d.Errors = append(d.Errors, Error{
+			Title: fmt.Sprintf("Service %s has invalid security options", service.Name),
+			Error: err,
+		})
+	}
+
+	if _, err := ParseRestartPolicy(service.Restart); err != nil {
+		d.Errors = append(d.Errors, Error{
+			Title: fmt.Sprintf("Service %s has an invalid restart policy %s", service.Name, service.Restart),
+			Error: err,
+		})
+	}
+}
+
+func (d *Diagnosis) ValidateHealthcheck(service *Service) {
+	check := service.Healthcheck
+	probes := 0
+
+	if check.HTTP != "" {
+		probes++
+
+		if !strings.HasPrefix(check.HTTP, "/") {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s has an invalid health check path", service.Name),
+				Error: fmt.Errorf("path %s must start with a /", check.HTTP),
+			})
+		}
+
+		if check.Status < 100 || check.Status > 599 {
+			d.Errors = append(d.Errors, Error{
+				Title: fmt.Sprintf("Service %s expects an invalid status code from its health check", service.Name),
+				Error: fmt.Errorf("status %d is not between 100 and 599", check.Status),
+			})
+		}
+	}
+
+	if check.TCP {
+		probes++
+	}
+
+	if check.Command != "" {
+		probes++
+	}
+
+	if probes != 1 {
+		d.Errors = append(d.Errors, Error{
+			Title: fmt.Sprintf("Service %s must define exactly one of http, tcp or command in its health check", service.Name),
+		})
+	}
+
+	if check.Interval < 0 || check.Timeout < 0 || check.StartPeriod < 0 || check.Retries < 0 {
+		d.Errors = append(d.Errors, Error{
+			Title: fmt.Sprintf("Service %s has a negative interval, timeout, start period or retries in its health check", service.Name),
+		})
+	}
+}
+
+func (d *Diagnosis) ValidateProxyConfiguration() {
+	directory, err := url.Parse(d.Config.Proxy.Acme.Directory)
+	if err != nil {
+		d.Errors = append(d.Errors, Error{
+			Title: "The ACME directory is not a valid URL",
+			Error: err,
+		})
+	} else if directory.Scheme != "https" && directory.Scheme != "http" {
+		d.Errors = append(d.Errors, Error{
+			Title: "The ACME directory must be an http(s) URL",
+			Error: fmt.Errorf("got %s", d.Config.Proxy.Acme.Directory),
+		})
+	}
+
+	if d.Config.Proxy.Acme.Email == "" {
+		d.Warnings = append(d.Warnings, Warning{
+			Title:  "No email is configured for the ACME account",
+			Advice: "Set proxy.acme.email to be notified before your certificates expire.",
+		})
 	}
 }
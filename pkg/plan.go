@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/redwebcreation/nest/docker"
+)
+
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionRemove    = "remove"
+	ActionUnchanged = "unchanged"
+)
+
+// Change is what a deployment would do to a service.
+type Change struct {
+	Service string `json:"service"`
+	Action  string `json:"action"`
+	// Reasons lists the parts of the configuration that changed when the service is updated.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Plan compares the configuration with the containers receiving the traffic of each service.
+func Plan(config *Configuration) ([]Change, error) {
+	containers, err := docker.GetNestContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	active := ActiveDeployments(containers, routes)
+	definitions := make(map[string]string)
+
+	for _, c := range containers {
+		name := c.Labels["cloud.usenest.service"]
+
+		if c.Labels["cloud.usenest.deployment_id"] == active[name] {
+			definitions[name] = c.Labels["cloud.usenest.service_configuration"]
+		}
+	}
+
+	var changes []Change
+
+	for name, service := range config.Services {
+		current, deployed := definitions[name]
+		if !deployed {
+			changes = append(changes, Change{Service: name, Action: ActionCreate})
+			continue
+		}
+
+		reasons, err := service.Diff(current)
+		if err != nil {
+			return nil, err
+		}
+
+		action := ActionUnchanged
+		if len(reasons) > 0 {
+			action = ActionUpdate
+		}
+
+		changes = append(changes, Change{Service: name, Action: action, Reasons: reasons})
+	}
+
+	for name := range definitions {
+		if _, ok := config.Services[name]; !ok {
+			changes = append(changes, Change{Service: name, Action: ActionRemove})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Service < changes[j].Service
+	})
+
+	return changes, nil
+}
+
+// OutdatedServices filters out the services whose running container was created from their current configuration.
+func OutdatedServices(services ServiceMap) (ServiceMap, error) {
+	containers, err := docker.GetNestContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	active := ActiveDeployments(containers, routes)
+	fingerprints := make(map[string]string)
+
+	for _, c := range containers {
+		name := c.Labels["cloud.usenest.service"]
+
+		if c.State == "running" && c.Labels["cloud.usenest.deployment_id"] == active[name] {
+			fingerprints[name] = c.Labels["cloud.usenest.fingerprint"]
+		}
+	}
+
+	outdated := make(ServiceMap)
+
+	for name, service := range services {
+		fingerprint, err := service.Fingerprint()
+		if err != nil {
+			return nil, err
+		}
+
+		if fingerprints[name] != fingerprint {
+			outdated[name] = service
+		}
+	}
+
+	return outdated, nil
+}
+
+// Diff lists the parts of the service's configuration that differ from the given definition.
+func (s *Service) Diff(definition string) ([]string, error) {
+	desired, err := s.Definition()
+	if err != nil {
+		return nil, err
+	}
+
+	if desired == definition {
+		return nil, nil
+	}
+
+	var current Service
+
+	// definitions written by older versions of nest may not decode anymore
+	if err = json.Unmarshal([]byte(definition), &current); err != nil {
+		return []string{"unknown previous configuration"}, nil
+	}
+
+	var reasons []string
+
+	if current.Image != s.Image {
+		reasons = append(reasons, "image")
+	}
+
+	if (len(current.Env) != 0 || len(s.Env) != 0) && !reflect.DeepEqual(current.Env, s.Env) {
+		reasons = append(reasons, "env")
+	}
+
+	if !reflect.DeepEqual(current.Hooks, s.Hooks) {
+		reasons = append(reasons, "hooks")
+	}
+
+	if !reflect.DeepEqual(current.Volumes, s.Volumes) || !reflect.DeepEqual(current.Binds, s.Binds) || !reflect.DeepEqual(current.Tmpfs, s.Tmpfs) {
+		reasons = append(reasons, "volumes")
+	}
+
+	if !reflect.DeepEqual(current.Resources, s.Resources) || current.Restart != s.Restart {
+		reasons = append(reasons, "resources")
+	}
+
+	if !reflect.DeepEqual(current.Security, s.Security) {
+		reasons = append(reasons, "security")
+	}
+
+	if current.Replicas != s.Replicas {
+		reasons = append(reasons, "replicas")
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, "configuration")
+	}
+
+	return reasons, nil
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestService_Diff(t *testing.T) {
+	current := &Service{
+		Name:  "api",
+		Image: "api:1",
+		Hosts: []string{"api.example.com"},
+		Env:   EnvMap{"FOO": "bar"},
+	}
+
+	definition, err := current.Definition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataset := []struct {
+		service Service
+		reasons []string
+	}{
+		{Service{Name: "api", Image: "api:1", Env: EnvMap{"FOO": "bar"}, Hosts: []string{"other.example.com"}}, nil},
+		{Service{Name: "api", Image: "api:2", Env: EnvMap{"FOO": "bar"}}, []string{"image"}},
+		{Service{Name: "api", Image: "api:2", Env: EnvMap{"FOO": "baz"}}, []string{"image", "env"}},
+		{Service{Name: "api", Image: "api:1", Env: EnvMap{"FOO": "bar"}, Binds: []string{"/data:/data"}}, []string{"volumes"}},
+		{Service{Name: "api", Image: "api:1", Env: EnvMap{"FOO": "bar"}, ListeningOn: "8080"}, []string{"configuration"}},
+		{Service{Name: "api", Image: "api:1", Env: EnvMap{"FOO": "bar"}, Replicas: 3}, []string{"replicas"}},
+		{Service{Name: "api", Image: "api:1", Env: EnvMap{"FOO": "bar"}, LoadBalancing: LeastConnections}, nil},
+	}
+
+	for _, d := range dataset {
+		reasons, err := d.service.Diff(definition)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(reasons, d.reasons) {
+			t.Errorf("Expected reasons to be %v, got %v", d.reasons, reasons)
+		}
+	}
+
+	reasons, _ := current.Diff("not json")
+	if !reflect.DeepEqual(reasons, []string{"unknown previous configuration"}) {
+		t.Errorf("Expected an unknown previous configuration, got %v", reasons)
+	}
+}
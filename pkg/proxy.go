@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/redwebcreation/nest/docker"
+)
+
+var (
+	ErrNoUpstream = fmt.Errorf("no container is running for this service")
+)
+
+// upstreamTTL is how long the containers resolved for a service are reused before asking docker again.
+const upstreamTTL = 2 * time.Second
+
+type upstream struct {
+	urls []*url.URL
+	// routes is the version of the routing table the urls were resolved with.
+	routes    os.FileInfo
+	expiresAt time.Time
+}
+
+// Proxy forwards incoming requests to the containers of the service accepting the request's host.
+type Proxy struct {
+	Config *Configuration
+
+	mu        sync.Mutex
+	upstreams map[string]upstream
+	balancers map[string]*Balancer
+	// generation counts the reloads, the upstreams resolved before a reload are not cached.
+	generation int
+	// containers lists the containers of a service.
+	containers func(service string) ([]types.Container, error)
+}
+
+func NewProxy(config *Configuration) *Proxy {
+	return &Proxy{
+		Config:     config,
+		upstreams:  make(map[string]upstream),
+		balancers:  make(map[string]*Balancer),
+		containers: docker.GetServiceContainers,
+	}
+}
+
+// Reload switches the proxy to a new configuration, such as the one of the latest deployment.
+func (p *Proxy) Reload(config *Configuration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Config = config
+	p.generation++
+	p.upstreams = make(map[string]upstream)
+	p.balancers = make(map[string]*Balancer)
+}
+
+// ServiceFor returns the service accepting the given host, exact matches take precedence over wildcards.
+func (p *Proxy) ServiceFor(host string) *Service {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	p.mu.Lock()
+	services := p.Config.Services
+	p.mu.Unlock()
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, h := range services[name].Hosts {
+			if strings.EqualFold(h, host) {
+				return services[name]
+			}
+		}
+	}
+
+	for _, name := range names {
+		if services[name].Accepts(host) {
+			return services[name]
+		}
+	}
+
+	return nil
+}
+
+// Upstreams returns the addresses of the running containers the service's traffic is routed to.
+// If none of the routed containers is running, the containers of the most recent deployment are used.
+// The addresses are resolved again as soon as the routing table changes, so that the containers a deployment retires
+// right after switching the traffic away from them are never used.
+// The lock is only held to read and replace the cached upstreams, the requests do not wait for docker meanwhile.
+func (p *Proxy) Upstreams(service *Service) ([]*url.URL, error) {
+	version := routesVersion()
+
+	p.mu.Lock()
+	u, ok := p.upstreams[service.Name]
+	generation := p.generation
+	p.mu.Unlock()
+
+	if ok && time.Now().Before(u.expiresAt) && sameRoutesVersion(u.routes, version) {
+		return u.urls, nil
+	}
+
+	routes, err := LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := p.containers(service.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var routed []string
+	var latest []string
+	var deploymentID string
+
+	for _, c := range containers {
+		ip := docker.IPAddress(c)
+		if c.State != "running" || ip == "" {
+			continue
+		}
+
+		if contains(routes[service.Name], c.ID) {
+			routed = append(routed, ip)
+		}
+
+		id := c.Labels["cloud.usenest.deployment_id"]
+
+		switch {
+		case id == deploymentID:
+			latest = append(latest, ip)
+		case isNewerDeployment(id, deploymentID):
+			latest = []string{ip}
+			deploymentID = id
+		}
+	}
+
+	addresses := routed
+	if len(addresses) == 0 {
+		addresses = latest
+	}
+
+	if len(addresses) == 0 {
+		return nil, ErrNoUpstream
+	}
+
+	sort.Strings(addresses)
+
+	urls := make([]*url.URL, 0, len(addresses))
+	for _, address := range addresses {
+		urls = append(urls, &url.URL{
+			Scheme: "http",
+			Host:   net.JoinHostPort(address, service.ListeningOn),
+		})
+	}
+
+	p.mu.Lock()
+	if p.generation == generation {
+		p.upstreams[service.Name] = upstream{
+			urls:      urls,
+			routes:    version,
+			expiresAt: time.Now().Add(upstreamTTL),
+		}
+	}
+	p.mu.Unlock()
+
+	return urls, nil
+}
+
+// Balancer returns the balancer spreading the requests of the service across its replicas.
+func (p *Proxy) Balancer(service *Service) *Balancer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b, ok := p.balancers[service.Name]
+	if !ok {
+		b = NewBalancer(service.LoadBalancing)
+		p.balancers[service.Name] = b
+	}
+
+	return b
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service := p.ServiceFor(r.Host)
+	if service == nil {
+		http.Error(w, "no service accepts this host", http.StatusNotFound)
+		return
+	}
+
+	upstreams, err := p.Upstreams(service)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	balancer := p.Balancer(service)
+
+	u := balancer.Pick(upstreams)
+	balancer.Acquire(u)
+	defer balancer.Release(u)
+
+	if r.TLS != nil {
+		r.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		r.Header.Set("X-Forwarded-Proto", "http")
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// the replica is not to blame for requests canceled by the client
+		if r.Context().Err() == nil {
+			balancer.MarkUnhealthy(u)
+		}
+
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// isNewerDeployment compares two deployment ids, they are unix timestamps in milliseconds.
+func isNewerDeployment(id string, than string) bool {
+	if len(id) != len(than) {
+		return len(id) > len(than)
+	}
+
+	return id > than
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestProxy_ServiceFor(t *testing.T) {
+	proxy := NewProxy(&Configuration{
+		Services: ServiceMap{
+			"wildcard": {Name: "wildcard", Hosts: []string{"*.example.com"}},
+			"api":      {Name: "api", Hosts: []string{"api.example.com"}},
+			"website":  {Name: "website", Hosts: []string{"example.com", "www.example.com"}},
+			"shop":     {Name: "shop", Hosts: []string{"Shop.Example.com"}},
+			"docs":     {Name: "docs", Hosts: []string{"*.Example.NET"}},
+		},
+	})
+
+	dataset := []struct {
+		host    string
+		service string
+	}{
+		{"example.com", "website"},
+		{"example.com:80", "website"},
+		{"WWW.EXAMPLE.COM", "website"},
+		{"api.example.com", "api"},
+		{"docs.example.com", "wildcard"},
+		{"shop.example.com", "shop"},
+		{"SHOP.example.com", "shop"},
+		{"api.example.net", "docs"},
+		{"example.org", ""},
+		{"a.docs.example.com", ""},
+	}
+
+	for _, d := range dataset {
+		service := proxy.ServiceFor(d.host)
+
+		if d.service == "" {
+			if service != nil {
+				t.Errorf("Expected no service for %s, got %s", d.host, service.Name)
+			}
+
+			continue
+		}
+
+		if service == nil || service.Name != d.service {
+			t.Errorf("Expected service %s for %s, got %v", d.service, d.host, service)
+		}
+	}
+}
+
+func TestProxy_UnknownHost(t *testing.T) {
+	proxy := NewProxy(&Configuration{})
+
+	recorder := httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://unknown.test/", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestProxy_UpstreamsFollowRoutes(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	container := func(id string, ip string) types.Container {
+		return types.Container{
+			ID:     id,
+			State:  "running",
+			Labels: map[string]string{"cloud.usenest.deployment_id": id},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{"nest": {IPAddress: ip}},
+			},
+		}
+	}
+
+	// the containers docker knows of, the previous one is removed once retired
+	running := []types.Container{container("1", "10.0.0.1")}
+
+	proxy := NewProxy(&Configuration{})
+	proxy.containers = func(service string) ([]types.Container, error) {
+		return running, nil
+	}
+
+	service := &Service{Name: "api", ListeningOn: "80"}
+
+	if err := RouteTraffic("api", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	upstreams, err := proxy.Upstreams(service)
+	if err != nil || len(upstreams) != 1 || upstreams[0].Host != "10.0.0.1:80" {
+		t.Fatalf("Expected the previous container, got %v: %v", upstreams, err)
+	}
+
+	// a deployment starts a new container, switches the traffic and retires the previous container right away
+	running = append(running, container("2", "10.0.0.2"))
+
+	if err = RouteTraffic("api", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	running = running[1:]
+
+	upstreams, err = proxy.Upstreams(service)
+	if err != nil || len(upstreams) != 1 || upstreams[0].Host != "10.0.0.2:80" {
+		t.Errorf("Expected only the new container once the traffic switched, got %v: %v", upstreams, err)
+	}
+}
+
+func TestProxy_UpstreamsDoNotWaitForDocker(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	running := types.Container{
+		ID:     "1",
+		State:  "running",
+		Labels: map[string]string{"cloud.usenest.deployment_id": "1"},
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{"nest": {IPAddress: "10.0.0.1"}},
+		},
+	}
+
+	// docker answers right away for api, the containers of web are listed until released
+	release := make(chan struct{})
+	listing := make(chan struct{})
+
+	proxy := NewProxy(&Configuration{})
+	proxy.containers = func(service string) ([]types.Container, error) {
+		if service == "web" {
+			close(listing)
+			<-release
+		}
+
+		return []types.Container{running}, nil
+	}
+
+	api := &Service{Name: "api", ListeningOn: "80"}
+
+	if _, err := proxy.Upstreams(api); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_, _ = proxy.Upstreams(&Service{Name: "web", ListeningOn: "80"})
+	}()
+
+	<-listing
+	defer close(release)
+
+	done := make(chan error)
+	go func() {
+		_, err := proxy.Upstreams(api)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the cached upstreams to be returned while docker lists the containers of another service")
+	}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import "sort"
+
+// DeployRequest selects the configuration and the services to deploy, it is shared by the CLI and the API.
+type DeployRequest struct {
+	// Commit of the configuration, a prefix is enough. The current commit is used if it is empty.
+	Commit string `json:"commit"`
+	// Services are patterns selecting the services to deploy, every service is selected if it is empty.
+	Services []string `json:"services"`
+	// Force redeploys the services that are up to date.
+	Force bool `json:"force"`
+	// Actor is who requested the deployment, it is recorded in the audit log.
+	Actor string `json:"-"`
+
+	// rollback is set if the deployment rolls services back, their previous deployments are marked once restored.
+	rollback *Rollback
+}
+
+// Load checks out the configuration at the requested commit and selects the services to deploy.
+func (r DeployRequest) Load() (*Configuration, ServiceMap, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	return r.load()
+}
+
+// load is Load for callers already holding configMu.
+func (r DeployRequest) load() (*Configuration, ServiceMap, error) {
+	commit := Config.Commit
+
+	if r.Commit != "" {
+		var err error
+
+		commit, err = ResolveCommit(r.Commit)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if commit != "" {
+		err := LoadConfigFromCommit(commit)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	config, err := Config.Retrieve()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	services, err := config.Services.Select(r.Services)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, services, nil
+}
+
+// Outdated filters out the services that are up to date unless the request forces their deployment.
+// The names of the services filtered out are returned in alphabetical order.
+func (r DeployRequest) Outdated(services ServiceMap) (ServiceMap, []string, error) {
+	if r.Force {
+		return services, nil, nil
+	}
+
+	outdated, err := OutdatedServices(services)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var skipped []string
+
+	for name := range services {
+		if _, ok := outdated[name]; !ok {
+			skipped = append(skipped, name)
+		}
+	}
+
+	sort.Strings(skipped)
+
+	return outdated, skipped, nil
+}
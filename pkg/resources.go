@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+var (
+	ErrInvalidRestartPolicy = fmt.Errorf("restart policy must be always, unless-stopped, on-failure[:<max retries>] or no")
+	ErrInvalidTmpfs         = fmt.Errorf("tmpfs must be in the format <target>[:<options>]")
+)
+
+var (
+	userRegex       = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]*)?$`)
+	capabilityRegex = regexp.MustCompile(`^(CAP_)?[A-Z_]+$`)
+)
+
+// Resources limits what the containers of a service may use on the host.
+type Resources struct {
+	// Memory is the maximum amount of memory, such as 512m or 2g.
+	Memory string `yaml:"memory"`
+	// CPUs is the number of CPUs the containers may use, such as 0.5 or 2.
+	CPUs float64 `yaml:"cpus"`
+	// PidsLimit is the maximum number of processes.
+	PidsLimit int64 `yaml:"pids_limit"`
+	// Ulimits maps a ulimit, such as nofile, to its value in the format <soft>[:<hard>].
+	Ulimits map[string]string `yaml:"ulimits"`
+}
+
+func (r Resources) ToDockerResources() (container.Resources, error) {
+	var resources container.Resources
+
+	if r.Memory != "" {
+		memory, err := units.RAMInBytes(r.Memory)
+		if err != nil {
+			return resources, err
+		}
+
+		resources.Memory = memory
+	}
+
+	resources.NanoCPUs = int64(r.CPUs * 1e9)
+
+	if r.PidsLimit != 0 {
+		limit := r.PidsLimit
+		resources.PidsLimit = &limit
+	}
+
+	names := make([]string, 0, len(r.Ulimits))
+	for name := range r.Ulimits {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		ulimit, err := units.ParseUlimit(name + "=" + r.Ulimits[name])
+		if err != nil {
+			return resources, err
+		}
+
+		resources.Ulimits = append(resources.Ulimits, ulimit)
+	}
+
+	return resources, nil
+}
+
+func (r Resources) Validate() error {
+	if _, err := r.ToDockerResources(); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(r.Memory, "-") {
+		return fmt.Errorf("memory limit %s must be positive", r.Memory)
+	}
+
+	if r.CPUs < 0 {
+		return fmt.Errorf("cpus limit %g must be positive", r.CPUs)
+	}
+
+	if r.PidsLimit < 0 {
+		return fmt.Errorf("pids limit %d must be positive", r.PidsLimit)
+	}
+
+	return nil
+}
+
+// Security hardens the containers of a service.
+type Security struct {
+	// User the processes run as, in the format <user>[:<group>], names or ids.
+	User string `yaml:"user"`
+	// ReadOnly mounts the root filesystem of the containers as read-only.
+	ReadOnly bool `yaml:"read_only"`
+	// CapAdd lists the kernel capabilities added to the default ones, such as NET_ADMIN.
+	CapAdd []string `yaml:"cap_add"`
+	// CapDrop lists the kernel capabilities removed from the default ones, ALL drops every capability.
+	CapDrop []string `yaml:"cap_drop"`
+	// NoNewPrivileges prevents the processes from gaining privileges, through setuid binaries for example.
+	NoNewPrivileges bool `yaml:"no_new_privileges"`
+}
+
+// SecurityOpt returns the docker security options of the containers.
+func (s Security) SecurityOpt() []string {
+	if s.NoNewPrivileges {
+		return []string{"no-new-privileges:true"}
+	}
+
+	return nil
+}
+
+func (s Security) Validate() error {
+	if s.User != "" && !userRegex.MatchString(s.User) {
+		return fmt.Errorf("user %s is not in the format <user>[:<group>]", s.User)
+	}
+
+	for _, capability := range append(append([]string{}, s.CapAdd...), s.CapDrop...) {
+		if !capabilityRegex.MatchString(capability) {
+			return fmt.Errorf("invalid capability %s, capabilities are uppercase such as NET_ADMIN", capability)
+		}
+	}
+
+	return nil
+}
+
+// ParseRestartPolicy parses a restart policy, containers always restart unless specified otherwise.
+func ParseRestartPolicy(policy string) (container.RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	name := parts[0]
+	hasRetries := len(parts) == 2
+
+	switch name {
+	case "":
+		return container.RestartPolicy{Name: "always"}, nil
+	case "always", "unless-stopped", "no":
+		if hasRetries {
+			return container.RestartPolicy{}, ErrInvalidRestartPolicy
+		}
+
+		return container.RestartPolicy{Name: name}, nil
+	case "on-failure":
+		restart := container.RestartPolicy{Name: name}
+
+		if hasRetries {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return container.RestartPolicy{}, ErrInvalidRestartPolicy
+			}
+
+			restart.MaximumRetryCount = count
+		}
+
+		return restart, nil
+	}
+
+	return container.RestartPolicy{}, ErrInvalidRestartPolicy
+}
+
+// ParseTmpfs parses a tmpfs mount in the format <target>[:<options>], such as /tmp:size=64m.
+func ParseTmpfs(tmpfs string) (target string, options string, err error) {
+	parts := strings.SplitN(tmpfs, ":", 2)
+
+	target = parts[0]
+	if len(parts) == 2 {
+		options = parts[1]
+	}
+
+	if !strings.HasPrefix(target, "/") {
+		return "", "", ErrInvalidTmpfs
+	}
+
+	return target, options, nil
+}
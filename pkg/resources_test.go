@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"gopkg.in/yaml.v3"
+)
+
+func TestResources_ToDockerResources(t *testing.T) {
+	var resources Resources
+
+	err := yaml.Unmarshal([]byte(`
+memory: 512m
+cpus: 1.5
+pids_limit: 100
+ulimits:
+  nofile: 1024:2048
+  nproc: 64
+`), &resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := resources.ToDockerResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Memory != 512*1024*1024 {
+		t.Errorf("Expected a memory limit of 512MB, got %d", r.Memory)
+	}
+
+	if r.NanoCPUs != 1500000000 {
+		t.Errorf("Expected 1.5 CPUs, got %d nano CPUs", r.NanoCPUs)
+	}
+
+	if r.PidsLimit == nil || *r.PidsLimit != 100 {
+		t.Errorf("Expected a pids limit of 100, got %v", r.PidsLimit)
+	}
+
+	if len(r.Ulimits) != 2 || r.Ulimits[0].Name != "nofile" || r.Ulimits[0].Soft != 1024 || r.Ulimits[0].Hard != 2048 || r.Ulimits[1].Hard != 64 {
+		t.Errorf("Unexpected ulimits %v", r.Ulimits)
+	}
+
+	invalid := []Resources{
+		{Memory: "lots"},
+		{CPUs: -1},
+		{PidsLimit: -1},
+		{Ulimits: map[string]string{"unknown": "1"}},
+		{Ulimits: map[string]string{"nofile": "1:2:3"}},
+	}
+
+	for _, r := range invalid {
+		if r.Validate() == nil {
+			t.Errorf("Expected %+v to be invalid", r)
+		}
+	}
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	dataset := []struct {
+		policy   string
+		expected container.RestartPolicy
+		fails    bool
+	}{
+		{"", container.RestartPolicy{Name: "always"}, false},
+		{"no", container.RestartPolicy{Name: "no"}, false},
+		{"unless-stopped", container.RestartPolicy{Name: "unless-stopped"}, false},
+		{"on-failure", container.RestartPolicy{Name: "on-failure"}, false},
+		{"on-failure:5", container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 5}, false},
+		{"on-failure:-1", container.RestartPolicy{}, true},
+		{"always:3", container.RestartPolicy{}, true},
+		{"sometimes", container.RestartPolicy{}, true},
+	}
+
+	for _, d := range dataset {
+		policy, err := ParseRestartPolicy(d.policy)
+		if d.fails {
+			if err == nil {
+				t.Errorf("Expected %s to be invalid", d.policy)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if policy != d.expected {
+			t.Errorf("Expected %s to parse into %v, got %v", d.policy, d.expected, policy)
+		}
+	}
+}
+
+func TestSecurity_Validate(t *testing.T) {
+	dataset := []struct {
+		security Security
+		valid    bool
+	}{
+		{Security{User: "www-data"}, true},
+		{Security{User: "1000:1000"}, true},
+		{Security{User: "www data"}, false},
+		{Security{CapDrop: []string{"ALL"}, CapAdd: []string{"NET_BIND_SERVICE", "CAP_CHOWN"}}, true},
+		{Security{CapAdd: []string{"net_admin"}}, false},
+	}
+
+	for _, d := range dataset {
+		if err := d.security.Validate(); (err == nil) != d.valid {
+			t.Errorf("Expected %+v to be valid: %v, got %v", d.security, d.valid, err)
+		}
+	}
+
+	if opts := (Security{NoNewPrivileges: true}).SecurityOpt(); len(opts) != 1 || opts[0] != "no-new-privileges:true" {
+		t.Errorf("Expected no-new-privileges to be set, got %v", opts)
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	target, options, err := ParseTmpfs("/tmp:size=64m,mode=1777")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target != "/tmp" || options != "size=64m,mode=1777" {
+		t.Errorf("Expected /tmp with size=64m,mode=1777, got %s with %s", target, options)
+	}
+
+	if _, _, err = ParseTmpfs("tmp"); err == nil {
+		t.Error("Expected a relative tmpfs target to be invalid")
+	}
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrNothingToRollBack = fmt.Errorf("nothing to roll back")
+)
+
+// RollbackRequest selects the services to roll back, it is shared by the CLI and the API.
+type RollbackRequest struct {
+	// Services are patterns selecting the services to roll back, every service is selected if it is empty.
+	Services []string `json:"services"`
+	// Actor is who requested the rollback, it is recorded in the audit log.
+	Actor string `json:"-"`
+}
+
+// RollbackResult lists the deployments restoring the services.
+type RollbackResult struct {
+	// Deployments restore the services, one per commit restored.
+	Deployments []*Deployment `json:"deployments"`
+	// Skipped lists the services without a previous successful deployment.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Rollback restores services from the commit of their previous successful deployment.
+type Rollback struct {
+	Commit   string
+	Services []string
+	// Replaced maps each service to the deployment it rolls back.
+	Replaced map[string]string
+}
+
+// PlanRollbacks groups the services by the commit they are restored from.
+// The services without a previous successful deployment are returned apart, in alphabetical order.
+func (h History) PlanRollbacks(names []string) ([]Rollback, []string) {
+	names = append([]string{}, names...)
+	sort.Strings(names)
+
+	var rollbacks []Rollback
+	var skipped []string
+
+	commits := make(map[string]int)
+
+	for _, name := range names {
+		current, target := h.RollbackTarget(name)
+		if target == nil {
+			skipped = append(skipped, name)
+			continue
+		}
+
+		i, ok := commits[target.Commit]
+		if !ok {
+			i = len(rollbacks)
+			commits[target.Commit] = i
+
+			rollbacks = append(rollbacks, Rollback{
+				Commit:   target.Commit,
+				Replaced: make(map[string]string),
+			})
+		}
+
+		rollbacks[i].Services = append(rollbacks[i].Services, name)
+		rollbacks[i].Replaced[name] = current.ID
+	}
+
+	return rollbacks, skipped
+}
+
+// MarkRolledBack marks the deployments replaced by the services the deployment restored.
+func (r Rollback) MarkRolledBack(deployment *Deployment) error {
+	for _, name := range r.Services {
+		deployment.mu.Lock()
+		service, ok := deployment.Services[name]
+		restored := ok && service.Status == StatusSucceeded
+		deployment.mu.Unlock()
+
+		if !restored {
+			continue
+		}
+
+		replaced, err := LoadDeployment(r.Replaced[name])
+		if err != nil {
+			return err
+		}
+
+		replaced.Services[name].RolledBack = true
+
+		err = replaced.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistory_PlanRollbacks(t *testing.T) {
+	history := History{
+		{ID: "3", Commit: "c", Services: map[string]*ServiceDeployment{"api": {Status: StatusSucceeded}, "web": {Status: StatusSucceeded}}},
+		{ID: "2", Commit: "b", Services: map[string]*ServiceDeployment{"api": {Status: StatusSucceeded}}},
+		{ID: "1", Commit: "a", Services: map[string]*ServiceDeployment{"web": {Status: StatusSucceeded}, "db": {Status: StatusSucceeded}}},
+	}
+
+	rollbacks, skipped := history.PlanRollbacks([]string{"web", "db", "api"})
+
+	expected := []Rollback{
+		{Commit: "b", Services: []string{"api"}, Replaced: map[string]string{"api": "3"}},
+		{Commit: "a", Services: []string{"web"}, Replaced: map[string]string{"web": "3"}},
+	}
+
+	if !reflect.DeepEqual(rollbacks, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, rollbacks)
+	}
+
+	if !reflect.DeepEqual(skipped, []string{"db"}) {
+		t.Errorf("Expected db to be skipped, got %v", skipped)
+	}
+}
+
+func TestRollback_MarkRolledBack(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	replaced := NewDeployment("b")
+	replaced.ID = "1000"
+	replaced.Services["api"] = &ServiceDeployment{Status: StatusSucceeded}
+	replaced.Services["web"] = &ServiceDeployment{Status: StatusSucceeded}
+
+	if err := replaced.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	deployment := NewDeployment("a")
+	deployment.Services["api"] = &ServiceDeployment{Status: StatusSucceeded}
+	deployment.Services["web"] = &ServiceDeployment{Status: StatusFailed}
+
+	rollback := Rollback{
+		Commit:   "a",
+		Services: []string{"api", "web"},
+		Replaced: map[string]string{"api": "1000", "web": "1000"},
+	}
+
+	if err := rollback.MarkRolledBack(deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, err := LoadDeployment("1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !replaced.Services["api"].RolledBack || replaced.Services["web"].RolledBack {
+		t.Errorf("Expected only the services restored to be marked as rolled back, got %+v %+v", replaced.Services["api"], replaced.Services["web"])
+	}
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/redwebcreation/nest/global"
+)
+
+// Routes maps a service to the containers receiving its traffic.
+type Routes map[string][]string
+
+// routesMu serializes the updates of the routing table between concurrent deployments of the process,
+// lockRoutes serializes them between processes.
+var routesMu sync.Mutex
+
+func routesPath() string {
+	return global.DataDir + "/routes.json"
+}
+
+// routesVersion identifies the routing table on disk, it is nil if there is none.
+// The table is replaced by a new file on every save, see Routes.Save.
+func routesVersion() os.FileInfo {
+	info, err := os.Stat(routesPath())
+	if err != nil {
+		return nil
+	}
+
+	return info
+}
+
+// sameRoutesVersion returns true if the routing table has not been saved since the first version was read.
+func sameRoutesVersion(a os.FileInfo, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// LoadRoutes reads the routing table, it is empty if no service has been deployed yet.
+func LoadRoutes() (Routes, error) {
+	routes := make(Routes)
+
+	contents, err := os.ReadFile(routesPath())
+	if os.IsNotExist(err) {
+		return routes, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &routes)
+	if err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// Save atomically replaces the routing table on disk, the proxy may read it at any time.
+func (r Routes) Save() error {
+	contents, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(global.DataDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp := routesPath() + ".tmp"
+
+	err = os.WriteFile(tmp, contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, routesPath())
+}
+
+// lockRoutes locks the routing table against the updates of the other nest processes,
+// such as the daemon deploying while the CLI runs. The lock is released by closing the file returned.
+func lockRoutes() (*os.File, error) {
+	err := os.MkdirAll(global.DataDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(routesPath()+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(lock)
+	if err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// RouteTraffic sends the traffic of the service to the given containers.
+func RouteTraffic(service string, containers ...string) error {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+
+	lock, err := lockRoutes()
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	routes, err := LoadRoutes()
+	if err != nil {
+		return err
+	}
+
+	routes[service] = containers
+
+	return routes.Save()
+}
+
+// ActiveDeployments returns the deployment receiving the traffic of each service.
+// If a service has not been routed yet, its most recent deployment is used.
+func ActiveDeployments(containers []types.Container, routes Routes) map[string]string {
+	active := make(map[string]string)
+
+	for _, c := range containers {
+		name := c.Labels["cloud.usenest.service"]
+		id := c.Labels["cloud.usenest.deployment_id"]
+
+		_, hasRoutes := routes[name]
+		if hasRoutes && !contains(routes[name], c.ID) {
+			continue
+		}
+
+		if isNewerDeployment(id, active[name]) {
+			active[name] = id
+		}
+	}
+
+	return active
+}
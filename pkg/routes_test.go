@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+func TestRouteTraffic(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	originalDataDir := global.DataDir
+	global.DataDir = dir
+	defer func() { global.DataDir = originalDataDir }()
+
+	routes, err := LoadRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routes) != 0 {
+		t.Errorf("Expected no routes, got %v", routes)
+	}
+
+	_ = RouteTraffic("api", "old")
+	_ = RouteTraffic("web", "web")
+	_ = RouteTraffic("api", "new")
+
+	routes, err = LoadRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Routes{"api": {"new"}, "web": {"web"}}
+
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Expected routes to be %v, got %v", expected, routes)
+	}
+}
+
+func TestRouteTraffic_Lock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the routing table is only locked across processes on unix")
+	}
+
+	defer useTempDataDir(t)()
+
+	// another process updating the routing table holds the lock
+	lock, err := lockRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- RouteTraffic("api", "1")
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected the routing table to stay locked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = lock.Close()
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	routes, _ := LoadRoutes()
+	if !reflect.DeepEqual(routes["api"], []string{"1"}) {
+		t.Errorf("Expected the traffic to be routed once the lock is released, got %v", routes)
+	}
+}
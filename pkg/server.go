@@ -0,0 +1,1039 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redwebcreation/nest/docker"
+)
+
+var (
+	ErrDeploymentInProgress = fmt.Errorf("a deployment is already in progress")
+	ErrUnauthorized         = fmt.Errorf("missing or invalid token")
+	ErrForbidden            = fmt.Errorf("the token is not allowed to do this")
+)
+
+// Server exposes the deployments and the services through an HTTP API.
+// Only one deployment runs at a time, the deployments requested meanwhile are either queued or refused.
+// Every request is recorded in the audit log.
+type Server struct {
+	// Token authenticates the requests with every scope, it is sent in the Authorization header as a bearer token.
+	// The tokens created with `nest token create` are accepted as well.
+	Token string
+	// WebhookSecret is shared with the git provider to sign its webhooks, webhooks are refused if it is empty.
+	WebhookSecret string
+	// Queue queues the deployments requested while another one runs instead of refusing them.
+	Queue bool
+	// OnFinish is called once a deployment finished, before the next one starts.
+	OnFinish func(deployment *Deployment)
+
+	// config returns the current configuration.
+	config func() (*Configuration, error)
+
+	mu      sync.Mutex
+	running bool
+	current *Deployment
+	// stream publishes the events of the current deployment.
+	stream  *Stream
+	pending []*queuedDeployment
+}
+
+// queuedDeployment is a deployment waiting for the current one to finish.
+type queuedDeployment struct {
+	request    DeployRequest
+	deployment *Deployment
+	stream     *Stream
+}
+
+func NewServer(token string, webhookSecret string) *Server {
+	return &Server{
+		Token:         token,
+		WebhookSecret: webhookSecret,
+		config:        RetrieveConfig,
+	}
+}
+
+// ContainerStatus is a container of a service as listed by the API.
+type ContainerStatus struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	DeploymentID string `json:"deployment_id"`
+	Replica      string `json:"replica"`
+	// Routed is true if the container receives the traffic of the service.
+	Routed bool `json:"routed"`
+}
+
+type ServiceStatus struct {
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`
+	Hosts      []string          `json:"hosts"`
+	Replicas   int               `json:"replicas"`
+	Containers []ContainerStatus `json:"containers"`
+}
+
+func (s *Server) Handler() http.Handler {
+	// webhooks are authenticated by their signature instead of the token
+	root := http.NewServeMux()
+	root.HandleFunc("/webhooks/", s.handleWebhook)
+	root.Handle("/", s.authenticate(s.routes()))
+
+	return s.audit(root)
+}
+
+// SocketHandler serves the API on the unix socket of the daemon.
+// Only the user running the daemon may connect to the socket, its requests are trusted with every scope.
+func (s *Server) SocketHandler() http.Handler {
+	return s.audit(s.trust(s.routes()))
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/deployments", s.handleDeployments)
+	mux.HandleFunc("/deployments/", s.handleDeployment)
+	mux.HandleFunc("/services", s.handleServices)
+	mux.HandleFunc("/rollbacks", s.handleRollbacks)
+	mux.HandleFunc("/gc", s.handleGc)
+
+	return mux
+}
+
+// actorHeader is sent by the CLI to tell the daemon who runs it, such as user:alice.
+const actorHeader = "X-Nest-Actor"
+
+// trust accepts every request with every scope.
+func (s *Server) trust(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get(actorHeader)
+		if actor == "" {
+			actor = "socket"
+		}
+
+		setActor(r, actor)
+
+		token := &AccessToken{ID: "socket", Scope: ScopeWrite}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessTokenKey, token)))
+	})
+}
+
+type contextKey int
+
+const (
+	auditEntryKey contextKey = iota
+	accessTokenKey
+)
+
+// statusRecorder remembers the status code of the response for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// audit records the request in the audit log once it has been answered.
+func (s *Server) audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &AuditEntry{
+			Time:       time.Now(),
+			Actor:      "anonymous",
+			Action:     r.Method + " " + r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), auditEntryKey, entry)))
+
+		entry.Status = recorder.status
+
+		_ = Audit(*entry)
+	})
+}
+
+// setActor records who sent the request in the audit log.
+func setActor(r *http.Request, actor string) {
+	if entry, ok := r.Context().Value(auditEntryKey).(*AuditEntry); ok {
+		entry.Actor = actor
+	}
+}
+
+// requestActor returns who sent the request, as recorded in the audit log.
+func requestActor(r *http.Request) string {
+	if entry, ok := r.Context().Value(auditEntryKey).(*AuditEntry); ok {
+		return entry.Actor
+	}
+
+	return "anonymous"
+}
+
+// requestToken returns the token the request was authenticated with.
+func requestToken(r *http.Request) *AccessToken {
+	token, _ := r.Context().Value(accessTokenKey).(*AccessToken)
+
+	return token
+}
+
+// authenticate accepts the requests sent with a token allowed to read the deployments.
+// The tokens are loaded for every request so that revoked tokens are refused right away.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		secret := strings.TrimPrefix(header, "Bearer ")
+
+		if secret == header || secret == "" {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+
+		var token *AccessToken
+
+		if s.Token != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s.Token)) == 1 {
+			token = &AccessToken{ID: "static", Scope: ScopeWrite}
+		} else {
+			tokens, err := LoadAccessTokens()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			token, err = tokens.Authenticate(secret)
+			if errors.Is(err, ErrTokenExpired) {
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+				return
+			}
+		}
+
+		setActor(r, "token:"+token.ID)
+
+		if !token.Allows(ScopeRead) {
+			writeError(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessTokenKey, token)))
+	})
+}
+
+// handleDeployments lists the deployments on GET and starts a deployment on POST.
+func (s *Server) handleDeployments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		history, err := LoadHistory()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		limit := 20
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %s", raw))
+				return
+			}
+		}
+
+		if limit > 0 && len(history) > limit {
+			history = history[:limit]
+		}
+
+		writeJSON(w, http.StatusOK, history)
+	case http.MethodPost:
+		var request DeployRequest
+
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		token := requestToken(r)
+		if !token.Allows(ScopeWrite) {
+			writeError(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+
+		// tokens restricted to some services are checked against the services the patterns select
+		if len(token.Services) > 0 {
+			services, err := s.allowedServices(token, request.Services)
+			if errors.Is(err, ErrForbidden) {
+				writeError(w, http.StatusForbidden, err)
+				return
+			}
+
+			if err != nil {
+				writeError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+
+			request.Services = services
+		}
+
+		request.Actor = requestActor(r)
+
+		deployment, err := s.Deploy(request)
+		if errors.Is(err, ErrDeploymentInProgress) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+
+		if errors.Is(err, ErrCommitNotFound) {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, deployment)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleDeployment returns the status and the events of a deployment.
+func (s *Server) handleDeployment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/deployments/")
+
+	if strings.HasSuffix(id, "/events") {
+		s.handleEvents(w, r, strings.TrimSuffix(id, "/events"))
+		return
+	}
+
+	if current, _ := s.live(id); current != nil {
+		writeJSON(w, http.StatusOK, current.Snapshot())
+		return
+	}
+
+	deployment, err := LoadDeployment(id)
+	if errors.Is(err, ErrDeploymentNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deployment)
+}
+
+// heartbeatInterval is how often a comment is sent to keep idle event streams open through proxies.
+const heartbeatInterval = 15 * time.Second
+
+// handleEvents streams the events of a deployment as server-sent events, followed by an end event once it finished.
+// The events of a finished deployment are replayed from its history.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	// clients reconnecting send the id of the last event they received
+	last := -1
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		var err error
+
+		last, err = strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %s", raw))
+			return
+		}
+	}
+
+	deployment, stream := s.live(id)
+
+	var backlog []RecordedEvent
+	var live <-chan RecordedEvent
+
+	if deployment != nil {
+		var cancel func()
+
+		backlog, live, cancel = stream.Subscribe()
+		defer cancel()
+	} else {
+		var err error
+
+		deployment, err = LoadDeployment(id)
+		if errors.Is(err, ErrDeploymentNotFound) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		backlog = deployment.Events
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// disables the response buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// the ids are the sequence numbers of the events, they are the same whether the events are streamed live or replayed
+	send := func(event RecordedEvent) error {
+		if event.Seq <= last {
+			return nil
+		}
+
+		return writeEvent(w, strconv.Itoa(event.Seq), "", event)
+	}
+
+	for _, event := range backlog {
+		if send(event) != nil {
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	if live != nil {
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+	stream:
+		for {
+			select {
+			case event, ok := <-live:
+				if !ok {
+					// the client was too slow and got dropped, it resumes from the last event when reconnecting
+					if !stream.Closed() {
+						return
+					}
+
+					break stream
+				}
+
+				if send(event) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+
+	summary := deployment.Snapshot()
+	summary.Events = nil
+
+	_ = writeEvent(w, "", "end", summary)
+	flusher.Flush()
+}
+
+// writeEvent writes a server-sent event whose data is v encoded as JSON.
+func writeEvent(w io.Writer, id string, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err = fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+
+	if name != "" {
+		if _, err = fmt.Fprintf(w, "event: %s\n", name); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+
+	return err
+}
+
+// handleRollbacks rolls the services back to their previous successful deployment.
+func (s *Server) handleRollbacks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	var request RollbackRequest
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	token := requestToken(r)
+	if !token.Allows(ScopeWrite) {
+		writeError(w, http.StatusForbidden, ErrForbidden)
+		return
+	}
+
+	services, err := s.allowedServices(token, request.Services)
+	if errors.Is(err, ErrForbidden) {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	request.Services = services
+	request.Actor = requestActor(r)
+
+	result, err := s.Rollback(request)
+	if errors.Is(err, ErrDeploymentInProgress) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	if errors.Is(err, ErrNothingToRollBack) || errors.Is(err, ErrCommitNotFound) {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, result)
+}
+
+// handleGc collects the stale containers.
+func (s *Server) handleGc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	// the collection removes the containers of any service, the tokens restricted to some services may not run it
+	token := requestToken(r)
+	if !token.Allows(ScopeWrite) || len(token.Services) > 0 {
+		writeError(w, http.StatusForbidden, ErrForbidden)
+		return
+	}
+
+	result, err := s.Collect()
+	if errors.Is(err, ErrDeploymentInProgress) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// allowedServices expands the patterns into the names of the services of the current configuration,
+// every service the token may deploy is selected if there is no pattern.
+// ErrForbidden is returned if the token may not deploy one of the services selected.
+func (s *Server) allowedServices(token *AccessToken, patterns []string) ([]string, error) {
+	config, err := s.config()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	if len(patterns) == 0 {
+		for name := range config.Services {
+			if token.AllowsService(name) {
+				names = append(names, name)
+			}
+		}
+
+		if len(names) == 0 {
+			return nil, fmt.Errorf("%w: no service may be deployed", ErrForbidden)
+		}
+
+		sort.Strings(names)
+
+		return names, nil
+	}
+
+	services, err := config.Services.Select(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range services {
+		if !token.AllowsService(name) {
+			return nil, fmt.Errorf("%w: deploy %s", ErrForbidden, name)
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// handleServices lists the services of the current configuration with their containers.
+func (s *Server) handleServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	config, err := s.config()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	statuses, err := ServiceStatuses(config)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// ServiceStatuses lists the services of the configuration with their containers, sorted by name.
+func ServiceStatuses(config *Configuration) ([]ServiceStatus, error) {
+	routes, err := LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]ServiceStatus, 0, len(config.Services))
+
+	for _, service := range config.Services {
+		containers, err := docker.GetServiceContainers(service.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		status := ServiceStatus{
+			Name:       service.Name,
+			Image:      service.Image,
+			Hosts:      service.Hosts,
+			Replicas:   service.Replicas,
+			Containers: make([]ContainerStatus, 0, len(containers)),
+		}
+
+		for _, c := range containers {
+			status.Containers = append(status.Containers, ContainerStatus{
+				ID:           c.ID,
+				Name:         strings.TrimPrefix(c.Names[0], "/"),
+				State:        c.State,
+				DeploymentID: c.Labels["cloud.usenest.deployment_id"],
+				Replica:      c.Labels["cloud.usenest.replica"],
+				Routed:       contains(routes[service.Name], c.ID),
+			})
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses, nil
+}
+
+// maxWebhookSize is the largest webhook payload accepted.
+const maxWebhookSize = 5 << 20
+
+// handleWebhook deploys the commit pushed to the branch of the configuration.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	locator := locatorConfig()
+
+	provider := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	if provider != locator.Provider {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the configuration is not hosted on %s", provider))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	push, err := ParseWebhook(provider, s.WebhookSecret, r.Header, body)
+	if err == nil {
+		setActor(r, "webhook:"+provider)
+	}
+
+	if errors.Is(err, ErrInvalidSignature) {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if push == nil || push.Branch != locator.Branch {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"message": "ignored, not a push to " + locator.Branch,
+		})
+		return
+	}
+
+	deployment, err := s.Deploy(DeployRequest{Commit: push.Commit, Actor: "webhook:" + provider})
+	if errors.Is(err, ErrDeploymentInProgress) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	if errors.Is(err, ErrCommitNotFound) {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, deployment)
+}
+
+// Deploy starts deploying the request in the background and returns a snapshot of the deployment.
+// If a deployment is already in progress, the request is queued if the server has a queue, it fails otherwise.
+func (s *Server) Deploy(request DeployRequest) (*Deployment, error) {
+	return s.deploy(request, s.Queue)
+}
+
+// Rollback rolls the services back, the services of each commit restored are redeployed together.
+// The request selects the services by their names, the deployments after the first one are queued.
+func (s *Server) Rollback(request RollbackRequest) (*RollbackResult, error) {
+	history, err := LoadHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	rollbacks, skipped := history.PlanRollbacks(request.Services)
+	if len(rollbacks) == 0 {
+		return nil, ErrNothingToRollBack
+	}
+
+	result := &RollbackResult{Skipped: skipped}
+
+	for i := range rollbacks {
+		deployment, err := s.deploy(DeployRequest{
+			Commit:   rollbacks[i].Commit,
+			Services: rollbacks[i].Services,
+			Force:    true,
+			Actor:    request.Actor,
+			rollback: &rollbacks[i],
+		}, s.Queue || i > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Deployments = append(result.Deployments, deployment)
+	}
+
+	return result, nil
+}
+
+// Collect collects the stale containers unless a deployment is in progress.
+func (s *Server) Collect() (*CollectResult, error) {
+	var result *CollectResult
+	var err error
+
+	ran := s.Exclusive(func() {
+		var config *Configuration
+
+		config, err = s.config()
+		if err != nil {
+			return
+		}
+
+		result, err = Collect(config)
+	})
+	if !ran {
+		return nil, ErrDeploymentInProgress
+	}
+
+	return result, err
+}
+
+// deploy starts the deployment, or queues it if another one is in progress and queue is true.
+func (s *Server) deploy(request DeployRequest, queue bool) (*Deployment, error) {
+	deployment := NewDeployment("")
+	stream := NewStream()
+
+	s.mu.Lock()
+	if s.running {
+		defer s.mu.Unlock()
+
+		if !queue {
+			return nil, ErrDeploymentInProgress
+		}
+
+		s.pending = append(s.pending, &queuedDeployment{
+			request:    request,
+			deployment: deployment,
+			stream:     stream,
+		})
+
+		return deployment.Snapshot(), nil
+	}
+	s.running = true
+	s.current = deployment
+	s.stream = stream
+	s.mu.Unlock()
+
+	err := s.start(request, deployment, stream)
+	if err != nil {
+		stream.Close()
+		s.release()
+
+		return nil, err
+	}
+
+	return deployment.Snapshot(), nil
+}
+
+// Exclusive runs fn unless a deployment is in progress, the deployments requested meanwhile wait for fn to return.
+// It returns false if fn did not run.
+func (s *Server) Exclusive(fn func()) bool {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return false
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	defer s.release()
+
+	fn()
+
+	return true
+}
+
+// live returns the deployment with the given id and its stream if it is in progress or queued.
+func (s *Server) live(id string) (*Deployment, *Stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil && s.current.ID == id {
+		return s.current, s.stream
+	}
+
+	for _, queued := range s.pending {
+		if queued.deployment.ID == id {
+			return queued.deployment, queued.stream
+		}
+	}
+
+	return nil, nil
+}
+
+// release starts the next queued deployment, the server is idle if there is none.
+func (s *Server) release() {
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+
+		next := s.pending[0]
+		s.pending = s.pending[1:]
+		s.current = next.deployment
+		s.stream = next.stream
+		s.mu.Unlock()
+
+		err := s.start(next.request, next.deployment, next.stream)
+		if err == nil {
+			return
+		}
+
+		// nobody waits for the response anymore, the error is kept in the history instead
+		next.deployment.fail(err)
+		_ = next.deployment.Save()
+
+		next.stream.Close()
+
+		if s.OnFinish != nil {
+			s.OnFinish(next.deployment)
+		}
+	}
+}
+
+// load checks out the configuration of the request and returns its commit.
+// The checkout is shared with the handlers reading the current configuration, it is held until the configuration is read.
+func (s *Server) load(request DeployRequest) (string, *Configuration, ServiceMap, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	// the server outlives the commit it started with, the latest commit is deployed by default
+	if request.Commit == "" {
+		commit, err := ResolveCommit("")
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		request.Commit = commit
+	}
+
+	config, services, err := request.load()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// a cycle is reported to the client right away rather than in the history of the deployment
+	err = services.CheckDependencies()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return Config.Commit, config, services, nil
+}
+
+func (s *Server) start(request DeployRequest, deployment *Deployment, stream *Stream) error {
+	commit, config, services, err := s.load(request)
+	if err != nil {
+		return err
+	}
+
+	services, skipped, err := request.Outdated(services)
+	if err != nil {
+		return err
+	}
+
+	// the services are known before the deployment runs so that its clients can follow them right away
+	deployment.mu.Lock()
+	deployment.Commit = commit
+	deployment.Skipped = skipped
+
+	for name, service := range services {
+		deployment.Services[name] = &ServiceDeployment{
+			Image:  service.Image,
+			Status: StatusPending,
+		}
+	}
+	deployment.mu.Unlock()
+
+	action := "deploy"
+
+	// a rollback restores services, the services removed from the configuration are left to the next deployment
+	if request.rollback != nil {
+		action = "rollback"
+		config = nil
+	}
+
+	err = AuditDeployment(request.Actor, action, deployment, services)
+	if err != nil {
+		return err
+	}
+
+	bus := make(MessageBus)
+	done := make(chan error, 1)
+
+	go func() {
+		err := deployment.Run(config, services, bus)
+		if err == nil && request.rollback != nil {
+			err = request.rollback.MarkRolledBack(deployment)
+		}
+
+		done <- err
+	}()
+
+	go func() {
+		// the events are recorded by the deployment itself, they are only streamed here
+		for message := range bus {
+			stream.Publish(message)
+		}
+
+		if err := <-done; err != nil {
+			deployment.fail(err)
+			_ = deployment.Save()
+		}
+
+		stream.Close()
+
+		if s.OnFinish != nil {
+			s.OnFinish(deployment)
+		}
+
+		s.release()
+	}()
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{
+		"error": err.Error(),
+	})
+}
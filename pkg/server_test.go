@@ -0,0 +1,404 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+// useTempDataDir stores the state of nest in a temporary directory, the returned function restores it.
+func useTempDataDir(t *testing.T) func() {
+	dir, err := os.MkdirTemp("", "nest-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originalDataDir := global.DataDir
+	global.DataDir = dir
+
+	return func() {
+		global.DataDir = originalDataDir
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestServer_Authentication(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	handler := NewServer("secret", "").Handler()
+
+	for _, header := range []string{"", "Bearer wrong", "secret"} {
+		request := httptest.NewRequest(http.MethodGet, "/deployments", nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %q to be unauthorized, got %d", header, recorder.Code)
+		}
+	}
+}
+
+func TestServer_Deployments(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	var err error
+
+	deployment := NewDeployment("commit")
+	deployment.ID = "1000"
+
+	if err = deployment.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer("secret", "")
+	handler := server.Handler()
+
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	recorder := request(http.MethodGet, "/deployments")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	var history []Deployment
+	if err = json.Unmarshal(recorder.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].ID != "1000" {
+		t.Errorf("Expected the deployment 1000 to be listed, got %s", recorder.Body)
+	}
+
+	if recorder = request(http.MethodGet, "/deployments/1000"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	if recorder = request(http.MethodGet, "/deployments/1"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	if recorder = request(http.MethodDelete, "/deployments"); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, recorder.Code)
+	}
+
+	server.running = true
+
+	if recorder = request(http.MethodPost, "/deployments"); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected concurrent deployments to be refused, got %d", recorder.Code)
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	var err error
+
+	api := &Service{Name: "api"}
+
+	finished := NewDeployment("commit")
+	finished.ID = "1000"
+	finished.Record(Message{Service: api, Event: Notice{Message: "first"}})
+	finished.Record(Message{Service: api, Event: PullProgress{Current: 1, Total: 2}})
+	finished.Record(Message{Service: api, Event: PullProgress{Current: 2, Total: 2}})
+	finished.Record(Message{Service: api, Event: Completed{}})
+
+	if err = finished.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer("secret", "")
+	handler := server.Handler()
+
+	request := func(path string, lastEventID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		if lastEventID != "" {
+			r.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	recorder := request("/deployments/1000/events", "")
+	if recorder.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, "id: 0\n") || strings.Contains(body, "id: 1\n") || !strings.Contains(body, "id: 2\n") || !strings.Contains(body, "id: 3\n") || !strings.HasSuffix(body, "\n\n") || !strings.Contains(body, "event: end\n") {
+		t.Errorf("Expected the recorded events followed by an end event, got %s", body)
+	}
+
+	if body = request("/deployments/1000/events", "1").Body.String(); strings.Contains(body, "id: 0\n") || !strings.Contains(body, "id: 2\n") || !strings.Contains(body, "id: 3\n") {
+		t.Errorf("Expected the events after the last event id, got %s", body)
+	}
+
+	if recorder = request("/deployments/1/events", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	// the current deployment is streamed live until its stream closes
+	current := NewDeployment("commit")
+	current.ID = "2000"
+
+	server.current = current
+	server.stream = NewStream()
+
+	// the ids streamed live are the ones replayed once the deployment is saved
+	for _, event := range []Event{Notice{Message: "live"}, PullProgress{Current: 1, Total: 2}, PullProgress{Current: 2, Total: 2}} {
+		message := Message{Service: api, Event: event}
+		message.Seq = current.Record(message)
+
+		server.stream.Publish(message)
+	}
+
+	done := make(chan string)
+	go func() {
+		done <- request("/deployments/2000/events", "").Body.String()
+	}()
+
+	server.stream.Close()
+
+	if body = <-done; !strings.Contains(body, "live") || !strings.Contains(body, "event: end\n") {
+		t.Errorf("Expected the live event followed by an end event, got %s", body)
+	}
+
+	if body = request("/deployments/2000/events", "1").Body.String(); !strings.Contains(body, "id: 2\n") || strings.Contains(body, "id: 1\n") {
+		t.Errorf("Expected the live events to keep their ids, got %s", body)
+	}
+
+	server.current = nil
+
+	if err = current.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if body = request("/deployments/2000/events", "1").Body.String(); !strings.Contains(body, "id: 2\n") || strings.Contains(body, "live") {
+		t.Errorf("Expected the replayed events to keep their live ids, got %s", body)
+	}
+}
+
+func TestServer_Scopes(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	var tokens AccessTokens
+
+	read, _, _ := tokens.Create(ScopeRead, nil, 0)
+	write, _, _ := tokens.Create(ScopeWrite, []string{"ap?"}, 0)
+	unrestricted, _, _ := tokens.Create(ScopeWrite, nil, 0)
+	revoked, token, _ := tokens.Create(ScopeWrite, nil, 0)
+
+	if err := tokens.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tokens.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer("", "")
+	server.running = true
+	server.config = func() (*Configuration, error) {
+		return &Configuration{Services: ServiceMap{
+			"api":  {Name: "api"},
+			"apps": {Name: "apps"},
+			"web":  {Name: "web"},
+		}}, nil
+	}
+
+	handler := server.Handler()
+
+	dataset := []struct {
+		token  string
+		method string
+		body   string
+		status int
+	}{
+		{read, http.MethodGet, "", http.StatusOK},
+		{read, http.MethodPost, "", http.StatusForbidden},
+		{revoked, http.MethodGet, "", http.StatusUnauthorized},
+		// the token only deploys the services matching ap?
+		{write, http.MethodPost, `{"services": ["web"]}`, http.StatusForbidden},
+		{write, http.MethodPost, `{"services": ["*"]}`, http.StatusForbidden},
+		{write, http.MethodPost, `{"services": ["ap*"]}`, http.StatusForbidden},
+		{write, http.MethodPost, `{"services": ["unknown"]}`, http.StatusUnprocessableEntity},
+		{write, http.MethodPost, `{"services": ["api"]}`, http.StatusConflict},
+		{write, http.MethodPost, `{"services": ["a?i"]}`, http.StatusConflict},
+		{write, http.MethodPost, "", http.StatusConflict},
+	}
+
+	for i, d := range dataset {
+		r := httptest.NewRequest(d.method, "/deployments", strings.NewReader(d.body))
+		r.Header.Set("Authorization", "Bearer "+d.token)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		if recorder.Code != d.status {
+			t.Errorf("#%d: expected status %d, got %d: %s", i, d.status, recorder.Code, recorder.Body)
+		}
+	}
+
+	contents, err := os.ReadFile(auditLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(contents), "\n"); lines != len(dataset) {
+		t.Errorf("Expected %d requests in the audit log, got %d", len(dataset), lines)
+	}
+
+	if !strings.Contains(string(contents), `"actor":"token:`+tokens[0].ID+`"`) {
+		t.Errorf("Expected the audit log to record the token, got %s", contents)
+	}
+
+	// only the tokens deploying every service may collect the containers
+	for secret, status := range map[string]int{read: http.StatusForbidden, write: http.StatusForbidden, unrestricted: http.StatusConflict} {
+		r := httptest.NewRequest(http.MethodPost, "/gc", nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		if recorder.Code != status {
+			t.Errorf("Expected the collection to answer %d, got %d: %s", status, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestServer_Queue(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	server := NewServer("", "")
+	server.running = true
+
+	if server.Exclusive(func() {}) {
+		t.Errorf("Expected nothing to run alongside a deployment")
+	}
+
+	if _, err := server.Deploy(DeployRequest{}); err != ErrDeploymentInProgress {
+		t.Errorf("Expected %s without a queue, got %v", ErrDeploymentInProgress, err)
+	}
+
+	server.Queue = true
+
+	queued, err := server.Deploy(DeployRequest{Services: []string{"api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deployment, _ := server.live(queued.ID); deployment == nil {
+		t.Errorf("Expected the queued deployment to be found")
+	}
+
+	if len(server.pending) != 1 || server.pending[0].request.Services[0] != "api" {
+		t.Errorf("Expected the request to be queued, got %d pending", len(server.pending))
+	}
+
+	// the deployments queued at once are told apart
+	next, err := server.Deploy(DeployRequest{Services: []string{"web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next.ID == queued.ID {
+		t.Errorf("Expected the queued deployments to have different ids, got %s twice", next.ID)
+	}
+
+	if deployment, _ := server.live(next.ID); deployment == nil || deployment.ID != next.ID {
+		t.Errorf("Expected the second queued deployment to be found, got %v", deployment)
+	}
+}
+
+func TestServer_Rollbacks(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	for _, deployment := range []*Deployment{
+		{ID: "1000", Commit: "a", Services: map[string]*ServiceDeployment{"api": {Status: StatusSucceeded}, "web": {Status: StatusSucceeded}}},
+		{ID: "2000", Commit: "b", Services: map[string]*ServiceDeployment{"api": {Status: StatusSucceeded}}},
+	} {
+		if err := deployment.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := NewServer("secret", "")
+	server.running = true
+	server.config = func() (*Configuration, error) {
+		return &Configuration{Services: ServiceMap{
+			"api": {Name: "api"},
+			"web": {Name: "web"},
+		}}, nil
+	}
+
+	handler := server.Handler()
+
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	if recorder := request(http.MethodPost, "/rollbacks", ""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected the rollback to be refused during a deployment without a queue, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if recorder := request(http.MethodPost, "/gc", ""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected the collection to be refused during a deployment, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if recorder := request(http.MethodPost, "/rollbacks", `{"services": ["web"]}`); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected nothing to roll back, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	server.Queue = true
+
+	recorder := request(http.MethodPost, "/rollbacks", "")
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("Expected the rollback to be queued, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var result RollbackResult
+
+	if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Deployments) != 1 || !reflect.DeepEqual(result.Skipped, []string{"web"}) {
+		t.Errorf("Expected api to be rolled back and web to be skipped, got %+v", result)
+	}
+
+	if len(server.pending) != 1 {
+		t.Fatalf("Expected the rollback to be queued, got %d pending", len(server.pending))
+	}
+
+	queued := server.pending[0].request
+	if queued.Commit != "a" || !queued.Force || queued.rollback == nil || queued.rollback.Replaced["api"] != "2000" {
+		t.Errorf("Expected api to be restored from commit a, got %+v", queued)
+	}
+}
@@ -1,7 +1,12 @@
 package pkg
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/redwebcreation/nest/docker"
 	"gopkg.in/yaml.v3"
+	"path"
 	"strings"
 )
 
@@ -17,22 +22,34 @@ type Service struct {
 	// Hosts the service responds to.
 	Hosts []string `yaml:"hosts"`
 
+	// HostGroups are the hosts sharing a certificate, ~example.com expands into a single group of two hosts.
+	HostGroups [][]string `yaml:"-"`
+
 	// Env variables for the service.
 	Env EnvMap `yaml:"env"`
 
 	// ListeningOn is the port the service listens on.
 	ListeningOn string `yaml:"listening_on"`
 
+	// Healthcheck decides when a new container is ready to receive traffic.
+	Healthcheck *Healthcheck `yaml:"healthcheck"`
+
+	// Replicas is the number of containers running the service, it defaults to 1.
+	Replicas int `yaml:"replicas"`
+
+	// LoadBalancing is how the proxy spreads requests across the replicas, round_robin or least_connections.
+	LoadBalancing string `yaml:"load_balancing"`
+
 	// Hooks are commands to run during the lifecycle of the service.
 	Hooks struct {
-		// Prestart is a list of commands to run before the service starts.
-		Prestart []string `yaml:"prestart"`
-		// Poststart is a list of commands to run after the service starts.
-		Poststart []string `yaml:"poststart"`
+		// Prestart is a list of commands to run in a one-off container before the containers of the service start.
+		Prestart []Hook `yaml:"prestart"`
+		// Poststart is a list of commands to run inside each container once it is ready.
+		Poststart []Hook `yaml:"poststart"`
 		// Preclean is a list of commands to run before the service is removed by the container collector.
-		Preclean []string `yaml:"preclean"`
+		Preclean []Hook `yaml:"preclean"`
 		// Postclean is a list of commands to run after the service is removed by the container collector.
-		Postclean []string `yaml:"postclean"`
+		Postclean []Hook `yaml:"postclean"`
 	} `yaml:"hooks"`
 
 	// Registry to pull the image from.
@@ -40,43 +57,97 @@ type Service struct {
 	Registry interface{} `yaml:"registry"`
 
 	// Volumes to mount for the service.
-	Volumes []struct {
-		// The path to mount from.
-		From string `yaml:"from"`
-		// The path to mount to.
-		To string `yaml:"to"`
-	} `yaml:"volumes"`
-
-	// Binds from the containers to the local filesystem.
+	Volumes []Volume `yaml:"volumes"`
+
+	// Binds from the containers to the local filesystem, in the format <source>:<target>[:<options>].
 	Binds []string `yaml:"binds"`
+
+	// Tmpfs mounts in the format <target>[:<options>], such as /tmp:size=64m.
+	Tmpfs []string `yaml:"tmpfs"`
+
+	// Resources limits what the containers may use on the host.
+	Resources Resources `yaml:"resources"`
+
+	// Security hardens the containers.
+	Security Security `yaml:"security"`
+
+	// Restart policy of the containers, always unless specified otherwise.
+	Restart string `yaml:"restart"`
+
+	// DependsOn lists the services that must be deployed and healthy before this service is deployed.
+	DependsOn []string `yaml:"depends_on"`
+
+	// Networks the service is attached to, services only reach the services sharing one of their networks.
+	// It defaults to the network shared by every service.
+	Networks []string `yaml:"networks"`
+}
+
+// SharedNetwork is the network services are attached to unless they declare their own networks.
+const SharedNetwork = "nest"
+
+// NetworkName returns the name of the docker network backing a network declared in the configuration.
+func NetworkName(network string) string {
+	if network == SharedNetwork {
+		return network
+	}
+
+	return SharedNetwork + "_" + network
 }
 
 func (s *Service) Normalize(serviceName string) {
 	s.Name = serviceName
 
 	var expandedHosts []string
+	var hostGroups [][]string
 
 	for _, host := range s.Hosts {
+		// hosts are case insensitive, the proxy and the certificates compare them in lowercase
+		host = strings.ToLower(host)
+
 		// expand ~example.com into example.com and www.example.com
 		if strings.HasPrefix(host, "~") {
-			expandedHosts = append(expandedHosts, host[1:])
-			expandedHosts = append(expandedHosts, "www."+host[1:])
+			group := []string{host[1:], "www." + host[1:]}
+
+			expandedHosts = append(expandedHosts, group...)
+			hostGroups = append(hostGroups, group)
 		} else {
 			expandedHosts = append(expandedHosts, host)
+			hostGroups = append(hostGroups, []string{host})
 		}
 	}
 
 	s.Hosts = expandedHosts
+	s.HostGroups = hostGroups
 
 	if s.ListeningOn == "" {
 		s.ListeningOn = "80"
 	} else {
 		s.ListeningOn = strings.TrimPrefix(s.ListeningOn, ":")
 	}
+
+	if s.Healthcheck != nil {
+		s.Healthcheck.Normalize()
+	}
+
+	if len(s.Networks) == 0 {
+		s.Networks = []string{SharedNetwork}
+	}
+
+	if s.Replicas == 0 {
+		s.Replicas = 1
+	}
+
+	if s.LoadBalancing == "" {
+		s.LoadBalancing = RoundRobin
+	}
 }
 
 func (s *Service) Accepts(host string) bool {
+	host = strings.ToLower(host)
+
 	for _, h := range s.Hosts {
+		h = strings.ToLower(h)
+
 		if h == host {
 			return true
 		}
@@ -84,6 +155,10 @@ func (s *Service) Accepts(host string) bool {
 		accepted := strings.Split(h, ".")
 		comparison := strings.Split(host, ".")
 
+		if len(accepted) != len(comparison) {
+			continue
+		}
+
 		for i := range comparison {
 			if accepted[i] == "*" {
 				comparison[i] = "*"
@@ -103,8 +178,90 @@ func (s *Service) Accepts(host string) bool {
 	return false
 }
 
+// Definition encodes the part of the configuration the containers of the service are created from.
+func (s *Service) Definition() (string, error) {
+	service := *s
+	service.Include = ""
+	service.Hosts = nil
+	service.HostGroups = nil
+	service.Registry = nil
+	service.DependsOn = nil
+	service.LoadBalancing = ""
+
+	definition, err := json.Marshal(service)
+	if err != nil {
+		return "", err
+	}
+
+	return string(definition), nil
+}
+
+// Fingerprint identifies the configuration the containers of the service are created from,
+// including the registry the image is pulled from and its credentials.
+func (s *Service) Fingerprint() (string, error) {
+	definition, err := s.Definition()
+	if err != nil {
+		return "", err
+	}
+
+	registry := s.registry()
+
+	// the name of the registry is only how the configuration refers to it
+	identity, err := json.Marshal([]string{registry.Host, registry.Port, registry.Username, registry.Password})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(definition+string(identity)))), nil
+}
+
+// registry returns the registry the image is pulled from, the zero registry is docker hub.
+func (s *Service) registry() docker.Registry {
+	switch registry := s.Registry.(type) {
+	case docker.Registry:
+		return registry
+	case *docker.Registry:
+		if registry != nil {
+			return *registry
+		}
+	}
+
+	return docker.Registry{}
+}
+
 type ServiceMap map[string]*Service
 
+// Select returns the services whose name matches one of the patterns, such as api or api-*.
+func (s ServiceMap) Select(patterns []string) (ServiceMap, error) {
+	if len(patterns) == 0 {
+		return s, nil
+	}
+
+	selected := make(ServiceMap)
+
+	for _, pattern := range patterns {
+		matched := false
+
+		for name, service := range s {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+			}
+
+			if ok {
+				selected[name] = service
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("no service matches %s", pattern)
+		}
+	}
+
+	return selected, nil
+}
+
 func (s *ServiceMap) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var services map[string]*Service
 	if err := unmarshal(&services); err != nil {
@@ -1,7 +1,15 @@
 package pkg
 
 import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/redwebcreation/nest/docker"
+	"gopkg.in/yaml.v3"
 )
 
 func TestService_Accepts(t *testing.T) {
@@ -25,6 +33,46 @@ func TestService_Accepts(t *testing.T) {
 		t.Error("Service should not accept empty string")
 	}
 
+	if service.Accepts("a.b.c.example.com") {
+		t.Error("Service should not accept a.b.c.example.com")
+	}
+
+}
+
+func TestService_Healthcheck(t *testing.T) {
+	var service Service
+	err := yaml.Unmarshal([]byte(strings.TrimSpace(`
+healthcheck:
+  http: /health
+  interval: 5s
+  start_period: 1m`)), &service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service.Normalize("example")
+
+	check := service.Healthcheck
+
+	if check.HTTP != "/health" {
+		t.Errorf("Expected path to be /health, got %s", check.HTTP)
+	}
+
+	if check.Status != http.StatusOK {
+		t.Errorf("Expected status to default to 200, got %d", check.Status)
+	}
+
+	if check.Interval != 5*time.Second {
+		t.Errorf("Expected interval to be 5s, got %s", check.Interval)
+	}
+
+	if check.StartPeriod != time.Minute {
+		t.Errorf("Expected start period to be 1m, got %s", check.StartPeriod)
+	}
+
+	if check.Timeout != time.Second || check.Retries != 10 {
+		t.Errorf("Expected timeout and retries to default to 1s and 10, got %s and %d", check.Timeout, check.Retries)
+	}
 }
 
 func TestServiceMap_IncludeService(t *testing.T) {
@@ -59,3 +107,120 @@ func TestServiceMap_IncludeService(t *testing.T) {
 	//	t.Errorf("expected listening_on to be 5016, got %s", sm["example"].ListeningOn)
 	//}
 }
+
+func TestService_Fingerprint(t *testing.T) {
+	a := &Service{Name: "api", Image: "api:1", Hosts: []string{"a.example.com"}}
+	b := &Service{Name: "api", Image: "api:1", Hosts: []string{"b.example.com"}}
+	c := &Service{Name: "api", Image: "api:2"}
+
+	fa, _ := a.Fingerprint()
+	fb, _ := b.Fingerprint()
+	fc, _ := c.Fingerprint()
+
+	if fa != fb {
+		t.Error("Expected hosts not to change the fingerprint")
+	}
+
+	if fa == fc {
+		t.Error("Expected the image to change the fingerprint")
+	}
+
+	dataset := []struct {
+		registry interface{}
+		changed  bool
+	}{
+		{nil, false},
+		{docker.Registry{}, false},
+		{&docker.Registry{Name: "renamed"}, false},
+		{&docker.Registry{Host: "registry.example.com"}, true},
+		{docker.Registry{Host: "registry.example.com", Port: "5000"}, true},
+		{&docker.Registry{Username: "deploy"}, true},
+		{&docker.Registry{Password: "secret"}, true},
+	}
+
+	for _, data := range dataset {
+		fingerprint, _ := (&Service{Name: "api", Image: "api:1", Hosts: []string{"a.example.com"}, Registry: data.registry}).Fingerprint()
+
+		if (fingerprint != fa) != data.changed {
+			t.Errorf("Expected the registry %+v to change the fingerprint: %v", data.registry, data.changed)
+		}
+	}
+}
+
+func TestServiceMap_Select(t *testing.T) {
+	services := ServiceMap{
+		"api":        {Name: "api"},
+		"api-worker": {Name: "api-worker"},
+		"api-cron":   {Name: "api-cron"},
+		"website":    {Name: "website"},
+	}
+
+	dataset := []struct {
+		patterns []string
+		selected []string
+		fails    bool
+	}{
+		{nil, []string{"api", "api-cron", "api-worker", "website"}, false},
+		{[]string{"api"}, []string{"api"}, false},
+		{[]string{"api-*"}, []string{"api-cron", "api-worker"}, false},
+		{[]string{"website", "api-w*"}, []string{"api-worker", "website"}, false},
+		{[]string{"unknown"}, nil, true},
+		{[]string{"[api"}, nil, true},
+	}
+
+	for _, d := range dataset {
+		selected, err := services.Select(d.patterns)
+		if d.fails {
+			if err == nil {
+				t.Errorf("Expected %v to fail", d.patterns)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for name := range selected {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, d.selected) {
+			t.Errorf("Expected %v to select %v, got %v", d.patterns, d.selected, names)
+		}
+	}
+}
+
+func TestService_Networks(t *testing.T) {
+	service := Service{}
+	service.Normalize("api")
+
+	if !reflect.DeepEqual(service.Networks, []string{SharedNetwork}) {
+		t.Errorf("Expected the service to be attached to the shared network, got %v", service.Networks)
+	}
+
+	if NetworkName(SharedNetwork) != "nest" {
+		t.Errorf("Expected the shared network to be named nest, got %s", NetworkName(SharedNetwork))
+	}
+
+	if NetworkName("backend") != "nest_backend" {
+		t.Errorf("Expected the backend network to be named nest_backend, got %s", NetworkName("backend"))
+	}
+}
+
+func TestService_NormalizeHosts(t *testing.T) {
+	service := Service{Hosts: []string{"~Example.com", "API.example.com"}}
+	service.Normalize("website")
+
+	if !reflect.DeepEqual(service.Hosts, []string{"example.com", "www.example.com", "api.example.com"}) {
+		t.Errorf("Expected the hosts to be expanded in lowercase, got %v", service.Hosts)
+	}
+
+	if !reflect.DeepEqual(service.HostGroups, [][]string{{"example.com", "www.example.com"}, {"api.example.com"}}) {
+		t.Errorf("Expected the host groups to be in lowercase, got %v", service.HostGroups)
+	}
+}
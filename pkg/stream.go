@@ -0,0 +1,96 @@
+package pkg
+
+import "sync"
+
+// subscriberBuffer is the number of events a subscriber may lag behind before being dropped.
+const subscriberBuffer = 256
+
+// Stream fans the messages of a deployment out to its subscribers.
+// Every event published is kept so that subscribers joining late receive the events they missed.
+type Stream struct {
+	mu          sync.Mutex
+	events      []RecordedEvent
+	subscribers map[chan RecordedEvent]struct{}
+	closed      bool
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		subscribers: make(map[chan RecordedEvent]struct{}),
+	}
+}
+
+// Publish sends the message to every subscriber.
+// Subscribers that do not keep up are dropped rather than slowing the deployment down.
+func (s *Stream) Publish(message Message) {
+	event, err := message.Serialize()
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.events = append(s.events, event)
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// Subscribe returns the events published so far and a channel receiving the next ones.
+// The channel is closed once the stream is closed or if the subscriber is dropped, cancel must be called when done.
+func (s *Stream) Subscribe() (backlog []RecordedEvent, events <-chan RecordedEvent, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriber := make(chan RecordedEvent, subscriberBuffer)
+	backlog = append([]RecordedEvent{}, s.events...)
+
+	if s.closed {
+		close(subscriber)
+		return backlog, subscriber, func() {}
+	}
+
+	s.subscribers[subscriber] = struct{}{}
+
+	return backlog, subscriber, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.subscribers[subscriber]; ok {
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// Close ends the stream, the channels of the subscribers are closed.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	for subscriber := range s.subscribers {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// Closed returns true once the stream has ended.
+func (s *Stream) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import "testing"
+
+func TestStream(t *testing.T) {
+	stream := NewStream()
+	api := &Service{Name: "api"}
+
+	stream.Publish(Message{Service: api, Event: Notice{Message: "first"}})
+
+	backlog, events, cancel := stream.Subscribe()
+	defer cancel()
+
+	if len(backlog) != 1 || backlog[0].Message != "first" {
+		t.Fatalf("Expected the backlog to contain the first event, got %v", backlog)
+	}
+
+	stream.Publish(Message{Service: api, Event: Notice{Message: "second"}})
+
+	if event := <-events; event.Message != "second" {
+		t.Errorf("Expected the second event, got %s", event.Message)
+	}
+
+	stream.Close()
+
+	if _, ok := <-events; ok {
+		t.Errorf("Expected the channel to be closed with the stream")
+	}
+
+	// subscribing to a closed stream replays it
+	backlog, events, _ = stream.Subscribe()
+	if _, ok := <-events; ok || len(backlog) != 2 {
+		t.Errorf("Expected a closed channel and 2 events, got %d events", len(backlog))
+	}
+}
+
+func TestStream_DropsSlowSubscribers(t *testing.T) {
+	stream := NewStream()
+	api := &Service{Name: "api"}
+
+	_, events, cancel := stream.Subscribe()
+	defer cancel()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		stream.Publish(Message{Service: api, Event: Notice{Message: "event"}})
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+
+	if received != subscriberBuffer || stream.Closed() {
+		t.Errorf("Expected the subscriber to be dropped after %d events, got %d", subscriberBuffer, received)
+	}
+}
@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+var (
+	ErrInvalidScope  = fmt.Errorf("scope must be either %s or %s", ScopeRead, ScopeWrite)
+	ErrTokenNotFound = fmt.Errorf("token not found")
+	ErrTokenExpired  = fmt.Errorf("token expired")
+)
+
+const (
+	// ScopeRead allows listing the deployments, their events and the services.
+	ScopeRead = "deploy:read"
+	// ScopeWrite allows deploying, it includes ScopeRead.
+	ScopeWrite = "deploy:write"
+)
+
+// tokenPrefix tells the API tokens apart from other secrets, such as in secret scanners.
+const tokenPrefix = "nest_"
+
+// AccessToken authenticates the requests to the API. Only its hash is stored, the token is shown once when created.
+type AccessToken struct {
+	ID string `json:"id"`
+	// Hash is the hex encoded SHA-256 of the token.
+	Hash  string `json:"hash"`
+	Scope string `json:"scope"`
+	// Services are patterns restricting the services the token deploys, it deploys every service if it is empty.
+	Services  []string   `json:"services,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired returns true if the token is past its expiry date.
+func (t *AccessToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// Allows returns true if the token has the given scope.
+func (t *AccessToken) Allows(scope string) bool {
+	return t.Scope == scope || t.Scope == ScopeWrite
+}
+
+// AllowsService returns true if the token may deploy the service.
+// The name is matched against the patterns of the token, patterns requested by a client must be expanded first.
+func (t *AccessToken) AllowsService(name string) bool {
+	if len(t.Services) == 0 {
+		return true
+	}
+
+	for _, allowed := range t.Services {
+		if ok, _ := path.Match(allowed, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+type AccessTokens []*AccessToken
+
+func tokensPath() string {
+	return global.DataDir + "/tokens.json"
+}
+
+// LoadAccessTokens reads the tokens, there are none until one is created.
+func LoadAccessTokens() (AccessTokens, error) {
+	var tokens AccessTokens
+
+	contents, err := os.ReadFile(tokensPath())
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Save atomically replaces the tokens on disk, the API may read them at any time.
+func (t AccessTokens) Save() error {
+	contents, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(global.DataDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp := tokensPath() + ".tmp"
+
+	err = os.WriteFile(tmp, contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, tokensPath())
+}
+
+// Create adds a token with the given scope, it never expires if ttl is zero.
+// The token returned must be given to its user, it can not be recovered from its hash.
+func (t *AccessTokens) Create(scope string, services []string, ttl time.Duration) (string, *AccessToken, error) {
+	if scope != ScopeRead && scope != ScopeWrite {
+		return "", nil, ErrInvalidScope
+	}
+
+	for _, pattern := range services {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &AccessToken{
+		ID:        id,
+		Hash:      hashToken(tokenPrefix + secret),
+		Scope:     scope,
+		Services:  services,
+		CreatedAt: time.Now(),
+	}
+
+	if ttl > 0 {
+		expiresAt := token.CreatedAt.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	*t = append(*t, token)
+
+	return tokenPrefix + secret, token, nil
+}
+
+// Revoke removes the token with the given id.
+func (t *AccessTokens) Revoke(id string) error {
+	for i, token := range *t {
+		if token.ID == id {
+			*t = append((*t)[:i], (*t)[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrTokenNotFound
+}
+
+// Authenticate returns the token matching the secret, unless it expired.
+func (t AccessTokens) Authenticate(secret string) (*AccessToken, error) {
+	hash := hashToken(secret)
+
+	for _, token := range t {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+			continue
+		}
+
+		if token.Expired() {
+			return nil, ErrTokenExpired
+		}
+
+		return token, nil
+	}
+
+	return nil, ErrTokenNotFound
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	bytes := make([]byte, size)
+
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redwebcreation/nest/global"
+)
+
+func TestAccessTokens(t *testing.T) {
+	var tokens AccessTokens
+
+	if _, _, err := tokens.Create("deploy:admin", nil, 0); err != ErrInvalidScope {
+		t.Errorf("Expected %s, got %v", ErrInvalidScope, err)
+	}
+
+	secret, token, err := tokens.Create(ScopeWrite, []string{"api-*"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Hash == secret || token.ExpiresAt == nil {
+		t.Errorf("Expected the token to be hashed and to expire, got %+v", token)
+	}
+
+	if authenticated, err := tokens.Authenticate(secret); err != nil || authenticated.ID != token.ID {
+		t.Errorf("Expected the token to be authenticated, got %v", err)
+	}
+
+	if _, err = tokens.Authenticate(secret + "x"); err != ErrTokenNotFound {
+		t.Errorf("Expected %s, got %v", ErrTokenNotFound, err)
+	}
+
+	if !token.Allows(ScopeRead) || !token.AllowsService("api-web") || token.AllowsService("db") {
+		t.Errorf("Expected the token to read and deploy only api-*")
+	}
+
+	expiredAt := time.Now().Add(-time.Minute)
+	token.ExpiresAt = &expiredAt
+
+	if _, err = tokens.Authenticate(secret); err != ErrTokenExpired {
+		t.Errorf("Expected %s, got %v", ErrTokenExpired, err)
+	}
+
+	if err = tokens.Revoke(token.ID); err != nil || len(tokens) != 0 {
+		t.Errorf("Expected the token to be revoked, got %v", err)
+	}
+
+	if err = tokens.Revoke(token.ID); err != ErrTokenNotFound {
+		t.Errorf("Expected %s, got %v", ErrTokenNotFound, err)
+	}
+
+	_, read, _ := tokens.Create(ScopeRead, nil, 0)
+	if read.Allows(ScopeWrite) || !read.AllowsService("db") || read.ExpiresAt != nil {
+		t.Errorf("Expected a read-only token for every service that never expires, got %+v", read)
+	}
+}
+
+func TestAccessTokens_Save(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	originalDataDir := global.DataDir
+	global.DataDir = dir
+	defer func() { global.DataDir = originalDataDir }()
+
+	tokens, err := LoadAccessTokens()
+	if err != nil || len(tokens) != 0 {
+		t.Fatalf("Expected no tokens, got %d: %v", len(tokens), err)
+	}
+
+	secret, _, err := tokens.Create(ScopeRead, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tokens.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err = LoadAccessTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = tokens.Authenticate(secret); err != nil {
+		t.Errorf("Expected the saved token to be authenticated, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+var (
+	ErrInvalidBind = fmt.Errorf("bind must be in the format <source>:<target>[:<options>]")
+)
+
+var volumeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+type Volume struct {
+	// From is the name of the volume, it is created if it does not exist.
+	From string `yaml:"from"`
+	// To is the path the volume is mounted at in the container.
+	To string `yaml:"to"`
+	// ReadOnly mounts the volume as read-only.
+	ReadOnly bool `yaml:"read_only"`
+}
+
+func (v Volume) ToDockerMount() mount.Mount {
+	return mount.Mount{
+		Type:     mount.TypeVolume,
+		Source:   v.From,
+		Target:   v.To,
+		ReadOnly: v.ReadOnly,
+	}
+}
+
+func (v Volume) Validate() error {
+	if !volumeNameRegex.MatchString(v.From) {
+		return fmt.Errorf("invalid volume name %s, use binds to mount a path of the host", v.From)
+	}
+
+	if !strings.HasPrefix(v.To, "/") {
+		return fmt.Errorf("volume %s must be mounted at an absolute path, got %s", v.From, v.To)
+	}
+
+	return nil
+}
+
+// Bind is a path of the host mounted in the container, in the format <source>:<target>[:<options>].
+type Bind struct {
+	Source  string
+	Target  string
+	Options []string
+}
+
+func ParseBind(bind string) (Bind, error) {
+	parts := strings.Split(bind, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Bind{}, ErrInvalidBind
+	}
+
+	b := Bind{
+		Source: parts[0],
+		Target: parts[1],
+	}
+
+	if len(parts) == 3 {
+		b.Options = strings.Split(parts[2], ",")
+	}
+
+	return b, nil
+}
+
+func (b Bind) Validate() error {
+	if !strings.HasPrefix(b.Source, "/") {
+		return fmt.Errorf("bind source %s must be an absolute path", b.Source)
+	}
+
+	if !strings.HasPrefix(b.Target, "/") {
+		return fmt.Errorf("bind target %s must be an absolute path", b.Target)
+	}
+
+	// options of the same kind are mutually exclusive
+	kinds := make(map[string]string)
+
+	for _, option := range b.Options {
+		var kind string
+
+		switch option {
+		case "ro", "rw":
+			kind = "access mode"
+		case "z", "Z":
+			kind = "selinux label"
+		case "private", "rprivate", "shared", "rshared", "slave", "rslave":
+			kind = "propagation"
+		default:
+			return fmt.Errorf("unknown bind option %s", option)
+		}
+
+		if previous, ok := kinds[kind]; ok {
+			return fmt.Errorf("bind options %s and %s are both a %s", previous, option, kind)
+		}
+
+		kinds[kind] = option
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestBind_Validate(t *testing.T) {
+	dataset := []struct {
+		bind  string
+		valid bool
+	}{
+		{"/srv/data:/data", true},
+		{"/srv/data:/data:ro", true},
+		{"/srv/data:/data:ro,Z,rshared", true},
+		{"/srv/data", false},
+		{"/srv/data:/data:ro:z", false},
+		{"data:/data", false},
+		{"/srv/data:data", false},
+		{"/srv/data:/data:ro,rw", false},
+		{"/srv/data:/data:z,Z", false},
+		{"/srv/data:/data:shared,slave", false},
+		{"/srv/data:/data:unknown", false},
+	}
+
+	for _, d := range dataset {
+		bind, err := ParseBind(d.bind)
+		if err == nil {
+			err = bind.Validate()
+		}
+
+		if d.valid && err != nil {
+			t.Errorf("Expected %s to be valid, got %s", d.bind, err)
+		}
+
+		if !d.valid && err == nil {
+			t.Errorf("Expected %s to be invalid", d.bind)
+		}
+	}
+}
+
+func TestVolume_Validate(t *testing.T) {
+	dataset := []struct {
+		volume Volume
+		valid  bool
+	}{
+		{Volume{From: "pgdata", To: "/var/lib/postgresql/data"}, true},
+		{Volume{From: "/srv/pgdata", To: "/var/lib/postgresql/data"}, false},
+		{Volume{From: "pgdata", To: "data"}, false},
+	}
+
+	for _, d := range dataset {
+		err := d.volume.Validate()
+
+		if d.valid && err != nil {
+			t.Errorf("Expected %v to be valid, got %s", d.volume, err)
+		}
+
+		if !d.valid && err == nil {
+			t.Errorf("Expected %v to be invalid", d.volume)
+		}
+	}
+}
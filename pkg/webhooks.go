@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidSignature = fmt.Errorf("invalid webhook signature")
+	ErrUnknownProvider  = fmt.Errorf("unknown webhook provider")
+)
+
+// PushEvent is the part of a push webhook nest deploys from.
+type PushEvent struct {
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+}
+
+// ParseWebhook verifies that the webhook was sent by the provider with the shared secret and returns the push it describes.
+// The event is nil if the webhook is not about a push to a branch, such as GitHub's ping.
+func ParseWebhook(provider string, secret string, header http.Header, body []byte) (*PushEvent, error) {
+	if secret == "" {
+		return nil, ErrInvalidSignature
+	}
+
+	switch provider {
+	case "github":
+		if !validSignature(header.Get("X-Hub-Signature-256"), secret, body) {
+			return nil, ErrInvalidSignature
+		}
+
+		if header.Get("X-GitHub-Event") != "push" {
+			return nil, nil
+		}
+
+		return parsePush(body)
+	case "gitlab":
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return nil, ErrInvalidSignature
+		}
+
+		if header.Get("X-Gitlab-Event") != "Push Hook" {
+			return nil, nil
+		}
+
+		return parsePush(body)
+	case "bitbucket":
+		if !validSignature(header.Get("X-Hub-Signature"), secret, body) {
+			return nil, ErrInvalidSignature
+		}
+
+		if header.Get("X-Event-Key") != "repo:push" {
+			return nil, nil
+		}
+
+		return parseBitbucketPush(body)
+	}
+
+	return nil, ErrUnknownProvider
+}
+
+// validSignature checks a signature in the format sha256=<hex encoded HMAC of the body>.
+func validSignature(signature string, secret string, body []byte) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// parsePush parses the push payloads of GitHub and GitLab, they share the fields nest needs.
+func parsePush(body []byte) (*PushEvent, error) {
+	var payload struct {
+		Ref   string `json:"ref"`
+		After string `json:"after"`
+	}
+
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// tags and deleted branches are not deployed
+	if !strings.HasPrefix(payload.Ref, "refs/heads/") || strings.Trim(payload.After, "0") == "" {
+		return nil, nil
+	}
+
+	return &PushEvent{
+		Branch: strings.TrimPrefix(payload.Ref, "refs/heads/"),
+		Commit: payload.After,
+	}, nil
+}
+
+func parseBitbucketPush(body []byte) (*PushEvent, error) {
+	var payload struct {
+		Push struct {
+			Changes []struct {
+				New *struct {
+					Type   string `json:"type"`
+					Name   string `json:"name"`
+					Target struct {
+						Hash string `json:"hash"`
+					} `json:"target"`
+				} `json:"new"`
+			} `json:"changes"`
+		} `json:"push"`
+	}
+
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last change is the most recent state of the pushed branches
+	for i := len(payload.Push.Changes) - 1; i >= 0; i-- {
+		change := payload.Push.Changes[i].New
+
+		if change != nil && change.Type == "branch" {
+			return &PushEvent{
+				Branch: change.Name,
+				Commit: change.Target.Hash,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sign(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhook(t *testing.T) {
+	push := `{"ref": "refs/heads/main", "after": "abc123"}`
+	bitbucketPush := `{"push": {"changes": [{"new": {"type": "branch", "name": "main", "target": {"hash": "abc123"}}}]}}`
+
+	dataset := []struct {
+		provider string
+		header   map[string]string
+		body     string
+		event    *PushEvent
+		err      error
+	}{
+		{"github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("secret", push)}, push, &PushEvent{"main", "abc123"}, nil},
+		{"github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("wrong", push)}, push, nil, ErrInvalidSignature},
+		{"github", map[string]string{"X-GitHub-Event": "push"}, push, nil, ErrInvalidSignature},
+		{"github", map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": sign("secret", "{}")}, "{}", nil, nil},
+		// tags and deleted branches are ignored
+		{"github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("secret", `{"ref": "refs/tags/v1"}`)}, `{"ref": "refs/tags/v1"}`, nil, nil},
+		{"github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("secret", `{"ref": "refs/heads/main", "after": "0000"}`)}, `{"ref": "refs/heads/main", "after": "0000"}`, nil, nil},
+		{"gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "secret"}, push, &PushEvent{"main", "abc123"}, nil},
+		{"gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, push, nil, ErrInvalidSignature},
+		{"bitbucket", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": sign("secret", bitbucketPush)}, bitbucketPush, &PushEvent{"main", "abc123"}, nil},
+		{"bitbucket", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": sign("wrong", bitbucketPush)}, bitbucketPush, nil, ErrInvalidSignature},
+		{"gitea", map[string]string{}, push, nil, ErrUnknownProvider},
+	}
+
+	for i, d := range dataset {
+		header := http.Header{}
+		for k, v := range d.header {
+			header.Set(k, v)
+		}
+
+		event, err := ParseWebhook(d.provider, "secret", header, []byte(d.body))
+		if err != d.err {
+			t.Errorf("#%d: expected error %v, got %v", i, d.err, err)
+		}
+
+		if (event == nil) != (d.event == nil) || (event != nil && *event != *d.event) {
+			t.Errorf("#%d: expected event %v, got %v", i, d.event, event)
+		}
+	}
+
+	if _, err := ParseWebhook("gitlab", "", http.Header{"X-Gitlab-Token": []string{""}}, []byte(push)); err != ErrInvalidSignature {
+		t.Errorf("Expected webhooks to be refused without a secret, got %v", err)
+	}
+}
+
+func TestServer_Webhook(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	originalConfig := Config
+	Config = &ConfigLocator{ConfigLocatorConfig: ConfigLocatorConfig{Provider: "github", Branch: "main"}}
+	defer func() { Config = originalConfig }()
+
+	handler := NewServer("token", "secret").Handler()
+
+	request := func(path string, body string, signature string) int {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("X-GitHub-Event", "push")
+		r.Header.Set("X-Hub-Signature-256", signature)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		return recorder.Code
+	}
+
+	push := `{"ref": "refs/heads/develop", "after": "abc123"}`
+
+	if code := request("/webhooks/gitlab", push, sign("secret", push)); code != http.StatusNotFound {
+		t.Errorf("Expected webhooks from another provider to be not found, got %d", code)
+	}
+
+	if code := request("/webhooks/github", push, sign("wrong", push)); code != http.StatusUnauthorized {
+		t.Errorf("Expected an invalid signature to be unauthorized, got %d", code)
+	}
+
+	if code := request("/webhooks/github", push, sign("secret", push)); code != http.StatusOK {
+		t.Errorf("Expected pushes to other branches to be ignored, got %d", code)
+	}
+}
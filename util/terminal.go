@@ -0,0 +1,13 @@
+package util
+
+import "os"
+
+// IsTerminal reports whether the file is a terminal rather than a pipe or a regular file.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}